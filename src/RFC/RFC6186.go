@@ -2,50 +2,44 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"os"
-	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/probe"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
 )
 
-// 解析电子邮件地址，提取出本地部分和域名部分
+// 解析电子邮件地址，提取出本地部分和域名部分 (域名为A-label形式, 用于DNS查询)
 func splitEmailAddress(emailAddress string) (string, string) {
-	parts := strings.Split(emailAddress, "@")
-	if len(parts) != 2 {
+	addr, err := utils.Parse_EmailAddress(emailAddress)
+	if err != nil {
 		return "", ""
 	}
-	return parts[0], parts[1]
+	return addr.LocalPart, addr.ASCIIDomain
 }
 
-// 查询并解析SRV记录
-func lookupSRV(service, proto, domain string) ([]*net.SRV, error) {
-	_, srvs, err := net.LookupSRV(service, proto, domain)
-	return srvs, err
+// 查询并解析SRV记录, 同时返回DNSSEC验证结果并对目标分类 (RFC 6186 section 6)
+func lookupSRV(service, proto, domain string) ([]utils.SRVTarget, utils.DNSSECResult, error) {
+	return utils.Lookup_SRVTargets(service, proto, domain)
 }
 
-// 根据优先级和权重选择SRV记录
-func selectSRVRecord(srvs []*net.SRV) *net.SRV {
-	if len(srvs) == 0 {
-		return nil
-	}
-
-	selected := srvs[0]
-	for _, srv := range srvs {
-		if srv.Priority < selected.Priority || (srv.Priority == selected.Priority && srv.Weight > selected.Weight) {
-			selected = srv
+// 根据优先级和权重选择SRV记录, 目标为"."的记录 (服务不可用) 不参与选择
+func selectSRVRecord(srvs []utils.SRVTarget) *utils.SRVTarget {
+	var selected *utils.SRVTarget
+	for i, srv := range srvs {
+		if srv.Class == utils.SRVUnavailable {
+			continue
+		}
+		if selected == nil || srv.Priority < selected.Priority || (srv.Priority == selected.Priority && srv.Weight > selected.Weight) {
+			selected = &srvs[i]
 		}
 	}
 	return selected
 }
 
-// 手动输入FQDN和端口信息
-func promptUserForFQDN(service string) (string, int) {
-	var fqdn string
-	var port int
-	fmt.Printf("Please enter the FQDN for the %s service: ", service)
-	fmt.Scanln(&fqdn)
-	fmt.Printf("Please enter the port for the %s service: ", service)
-	fmt.Scanln(&port)
-	return fqdn, port
+// 没有SRV记录时, 像Thunderbird的guessConfig一样猜测常见的主机名和端口
+func guessServer(guesser *probe.Guesser, domain string, protocol utils.MailProtocol) *probe.Guess {
+	best, _ := guesser.Guess_Protocol(domain, protocol)
+	return best
 }
 
 func main() {
@@ -62,27 +56,57 @@ func main() {
 	}
 
 	services := []struct {
-		Name    string
-		Service string
-		Proto   string
+		Name     string
+		Service  string
+		Proto    string
+		Protocol utils.MailProtocol
 	}{
-		{"SMTP Submission", "submission", "tcp"},
-		{"IMAP", "imap", "tcp"},
-		{"POP3", "pop3", "tcp"},
+		{"SMTP Submission", "submission", "tcp", utils.MailSMTP},
+		{"IMAP", "imap", "tcp", utils.MailIMAP},
+		{"POP3", "pop3", "tcp", utils.MailPOP3},
 	}
 
+	guesser := probe.New_Guesser()
+
+	found := make([]utils.SRVTarget, 0)
 	for _, s := range services {
 		fmt.Printf("Looking up SRV records for %s (_%s._%s.%s):\n", s.Name, s.Service, s.Proto, domain)
-		srvs, err := lookupSRV(s.Service, s.Proto, domain)
+		srvs, dnssec, err := lookupSRV(s.Service, s.Proto, domain)
 		if err != nil || len(srvs) == 0 {
-			fmt.Println("No SRV records found. Prompting user for FQDN and port information.")
-			fqdn, port := promptUserForFQDN(s.Name)
-			fmt.Printf("Using manual configuration for %s: FQDN=%s, Port=%d\n", s.Name, fqdn, port)
+			fmt.Println("No SRV records found. Guessing common hostnames and ports.")
+			guess := guessServer(guesser, domain, s.Protocol)
+			if guess == nil {
+				fmt.Printf("No working server found for %s\n", s.Name)
+				continue
+			}
+			fmt.Printf("Guessed configuration for %s: %v, confidence %.2f\n", s.Name, guess.Server, guess.Confidence)
+			for _, reason := range guess.Reasons {
+				fmt.Printf("  %s\n", reason)
+			}
 			continue
 		}
 
+		found = append(found, srvs...)
 		selectedSRV := selectSRVRecord(srvs)
+		if selectedSRV == nil {
+			// RFC 6186 section 4, 目标为"."表示该服务明确不可用, 不再猜测
+			fmt.Printf("%s is not available at %s\n", s.Name, domain)
+			continue
+		}
 		fmt.Printf("Selected SRV record for %s: Target=%s, Port=%d, Priority=%d, Weight=%d\n", s.Name, selectedSRV.Target, selectedSRV.Port, selectedSRV.Priority, selectedSRV.Weight)
+		fmt.Printf("DNSSEC: %s %s\n", dnssec.Status, dnssec.Reason)
+		if selectedSRV.Class == utils.SRVThirdParty {
+			// RFC 6186 section 6, 目标不在查询的域内且未经DNSSEC验证时应警告用户
+			fmt.Printf("Warning: %s is outside %s and the SRV record is not DNSSEC validated\n", selectedSRV.Target, domain)
+		} else {
+			fmt.Printf("Target: %s\n", selectedSRV.Class)
+		}
 	}
-}
 
+	report := utils.Summarize_SRVTargets(found)
+	fmt.Printf("SRV targets: %d, in-domain: %d, delegated provider: %d, unverified third party: %d\n",
+		report.Targets, report.ByClass[utils.SRVInDomain], report.ByClass[utils.SRVDelegatedProvider], report.ByClass[utils.SRVThirdParty])
+	for _, f := range report.Findings {
+		fmt.Println(f)
+	}
+}
@@ -0,0 +1,119 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// the SRV services of RFC 6186 and RFC 8314 describing mail servers
+var MailSRVServices = []string{"imaps", "imap", "pop3s", "pop3", "submissions", "submission"}
+
+// what each mechanism returned for one email address
+type Collection struct {
+	EmailAddress string
+	Autoconfig   *autoconfig.Discovery   // nil if autoconfig found nothing
+	Autodiscover *autodiscover.Discovery // nil if Autodiscover found nothing
+	SRV          []utils.SRVTarget       // every mail SRV target found
+	SRVDNSSEC    utils.DNSSECResult      // the worst status of the SRV lookups that returned records
+	Settings     map[utils.MailSource]*utils.MailSettings
+	Errors       map[utils.MailSource]error // why a mechanism has no settings
+}
+
+// run autoconfig, Autodiscover (emulating behavior) and the RFC 6186 SRV lookup for email_address
+func Collect_MailSettings(email_address string, suffixlistpath string, behavior autodiscover.ClientBehavior) (*Collection, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Collection{
+		EmailAddress: email_address,
+		Settings:     make(map[utils.MailSource]*utils.MailSettings),
+		Errors:       make(map[utils.MailSource]error),
+	}
+
+	if d, err := autoconfig.Discover_Autoconfig(email_address, suffixlistpath); err != nil {
+		c.Errors[utils.SourceAutoconfig] = err
+	} else {
+		c.Autoconfig = d
+		c.addAutoconfig(d)
+	}
+
+	if d, err := autodiscover.Discover(email_address, behavior); err != nil {
+		c.Errors[utils.SourceAutodiscover] = err
+	} else {
+		c.Autodiscover = d
+		c.add(utils.SourceAutodiscover, d.MailSettings())
+	}
+
+	c.SRV, c.SRVDNSSEC, err = Lookup_MailSRV(addr.ASCIIDomain)
+	if err != nil {
+		c.Errors[utils.SourceSRV] = err
+	} else {
+		c.add(utils.SourceSRV, utils.MailSettings_FromSRV(email_address, c.SRV))
+	}
+
+	return c, nil
+}
+
+// look up every service of `MailSRVServices` under domain
+func Lookup_MailSRV(domain string) ([]utils.SRVTarget, utils.DNSSECResult, error) {
+	return lookupSRVServices(domain, MailSRVServices)
+}
+
+func lookupSRVServices(domain string, services []string) ([]utils.SRVTarget, utils.DNSSECResult, error) {
+	targets := make([]utils.SRVTarget, 0)
+	worst := utils.DNSSECResult{Name: domain, Status: utils.DNSSECSecure}
+	var lastErr error
+	for _, service := range services {
+		found, dnssec, err := utils.Lookup_SRVTargets(service, "tcp", domain)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(found) > 0 && dnssecRank[dnssec.Status] > dnssecRank[worst.Status] {
+			worst = dnssec
+		}
+		targets = append(targets, found...)
+	}
+	if len(targets) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no mail SRV records for %v", domain)
+		}
+		return nil, utils.DNSSECResult{Name: domain, Status: utils.DNSSECIndeterminate}, lastErr
+	}
+	return targets, worst, nil
+}
+
+var dnssecRank = map[utils.DNSSECStatus]int{
+	utils.DNSSECSecure:        0,
+	utils.DNSSECInsecure:      1,
+	utils.DNSSECIndeterminate: 2,
+	utils.DNSSECBogus:         3,
+}
+
+func (c *Collection) addAutoconfig(d *autoconfig.Discovery) {
+	effective, err := autoconfig.Resolve_EffectiveSettings(d.Config, c.EmailAddress)
+	if err != nil {
+		c.Errors[utils.SourceAutoconfig] = err
+		return
+	}
+	c.add(utils.SourceAutoconfig, effective.MailSettings())
+}
+
+// settings without any IMAP, POP3 or SMTP server don't count, e.g. Exchange only Autodiscover responses
+func (c *Collection) add(source utils.MailSource, settings *utils.MailSettings) {
+	if settings != nil && len(settings.Incoming) == 0 && len(settings.Outgoing) == 0 && len(settings.Unavailable) > 0 {
+		c.Errors[source] = fmt.Errorf("the %v settings say %v are not available", source, strings.Join(settings.Unavailable, ", "))
+		return
+	}
+	if settings == nil || (len(settings.Incoming) == 0 && len(settings.Outgoing) == 0) {
+		c.Errors[source] = fmt.Errorf("no IMAP, POP3 or SMTP server in the %v settings", source)
+		return
+	}
+	c.Settings[source] = settings
+}
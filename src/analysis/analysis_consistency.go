@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// the order a typical client asks the mechanisms in. Thunderbird and the clients that copied its account setup
+// try autoconfig first, then Autodiscover, and the SRV records of RFC 6186 last.
+var DefaultPrecedence = []utils.MailSource{utils.SourceAutoconfig, utils.SourceAutodiscover, utils.SourceSRV}
+
+type Direction string
+
+const (
+	Incoming Direction = "incoming"
+	Outgoing Direction = "outgoing"
+)
+
+// one setting the sources disagree on, for the server each source prefers
+type Conflict struct {
+	Direction Direction
+	Field     string // "protocol", "hostname", "port", "security" or "username"
+	Values    map[utils.MailSource]string
+}
+
+func (c Conflict) String() string {
+	values := make([]string, 0, len(c.Values))
+	for _, source := range DefaultPrecedence {
+		if v, ok := c.Values[source]; ok {
+			values = append(values, fmt.Sprintf("%v=%v", source, v))
+		}
+	}
+	return fmt.Sprintf("%v %v differs: %v", c.Direction, c.Field, strings.Join(values, ", "))
+}
+
+type ConsistencyReport struct {
+	EmailAddress string
+	Present      []utils.MailSource // sources with settings, in precedence order
+	Missing      []utils.MailSource
+	Conflicts    []Conflict
+	Used         utils.MailSource // the source a client following the precedence ends up with, "" if none
+	Consistent   bool             // at least two sources and no conflicts
+}
+
+// aggregated over any number of reports
+type ConsistencyStats struct {
+	Addresses    int
+	Consistent   int
+	Inconsistent int                      // at least two sources that disagree
+	Single       int                      // only one source has settings
+	None         int                      // no source has settings
+	Missing      map[utils.MailSource]int // addresses the source has no settings for
+	Used         map[utils.MailSource]int
+	Conflicts    map[string]int // by field
+}
+
+// collect the settings of email_address and compare them
+func Analyze_Consistency(email_address string, suffixlistpath string) (*ConsistencyReport, error) {
+	c, err := Collect_MailSettings(email_address, suffixlistpath, autodiscover.BehaviorSpec)
+	if err != nil {
+		return nil, err
+	}
+	return c.Consistency(), nil
+}
+
+// compare the collected settings, with the `DefaultPrecedence`
+func (c *Collection) Consistency() *ConsistencyReport {
+	return Compare_MailSettings(c.EmailAddress, c.Settings, DefaultPrecedence)
+}
+
+// compare the server each source prefers for incoming and outgoing mail.
+// precedence lists the sources in the order a client asks them, sources not in it are ignored.
+func Compare_MailSettings(email_address string, settings map[utils.MailSource]*utils.MailSettings, precedence []utils.MailSource) *ConsistencyReport {
+	report := &ConsistencyReport{EmailAddress: email_address}
+	for _, source := range precedence {
+		if settings[source] == nil {
+			report.Missing = append(report.Missing, source)
+			continue
+		}
+		report.Present = append(report.Present, source)
+		if report.Used == "" {
+			report.Used = source
+		}
+	}
+
+	for _, direction := range []Direction{Incoming, Outgoing} {
+		preferred := make(map[utils.MailSource]*utils.MailServer)
+		for _, source := range report.Present {
+			var server *utils.MailServer
+			if direction == Incoming {
+				server = settings[source].Preferred_Incoming()
+			} else {
+				server = settings[source].Preferred_Outgoing()
+			}
+			if server != nil {
+				preferred[source] = server
+			}
+		}
+		report.Conflicts = append(report.Conflicts, compareServers(direction, email_address, preferred)...)
+	}
+
+	report.Consistent = len(report.Present) >= 2 && len(report.Conflicts) == 0
+	return report
+}
+
+func compareServers(direction Direction, email_address string, servers map[utils.MailSource]*utils.MailServer) []Conflict {
+	conflicts := make([]Conflict, 0)
+	if len(servers) < 2 {
+		return conflicts
+	}
+
+	field := func(name string, value func(s *utils.MailServer) string) bool {
+		values := make(map[utils.MailSource]string)
+		distinct := make(map[string]bool)
+		for source, s := range servers {
+			v := value(s)
+			if v == "" {
+				continue
+			}
+			values[source] = v
+			distinct[v] = true
+		}
+		if len(distinct) > 1 {
+			conflicts = append(conflicts, Conflict{Direction: direction, Field: name, Values: values})
+			return true
+		}
+		return false
+	}
+
+	protocolDiffers := field("protocol", func(s *utils.MailServer) string { return string(s.Protocol) })
+	field("hostname", func(s *utils.MailServer) string { return s.Hostname })
+	// ports and security of different protocols are not comparable
+	if !protocolDiffers {
+		field("port", func(s *utils.MailServer) string { return strconv.Itoa(s.Port) })
+		field("security", func(s *utils.MailServer) string { return string(s.Security) })
+	}
+	// clients log in with the email address when the source doesn't name a username
+	field("username", func(s *utils.MailServer) string {
+		if s.Username == "" {
+			return email_address
+		}
+		return s.Username
+	})
+	return conflicts
+}
+
+// aggregate the results of `Compare_MailSettings`
+func Summarize_Consistency(reports []*ConsistencyReport) ConsistencyStats {
+	stats := ConsistencyStats{
+		Missing:   make(map[utils.MailSource]int),
+		Used:      make(map[utils.MailSource]int),
+		Conflicts: make(map[string]int),
+	}
+	for _, r := range reports {
+		if r == nil {
+			continue
+		}
+		stats.Addresses++
+		switch {
+		case len(r.Present) == 0:
+			stats.None++
+		case len(r.Present) == 1:
+			stats.Single++
+		case r.Consistent:
+			stats.Consistent++
+		default:
+			stats.Inconsistent++
+		}
+		for _, source := range r.Missing {
+			stats.Missing[source]++
+		}
+		if r.Used != "" {
+			stats.Used[r.Used]++
+		}
+		for _, c := range r.Conflicts {
+			stats.Conflicts[c.Field]++
+		}
+	}
+	return stats
+}
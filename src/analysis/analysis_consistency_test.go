@@ -0,0 +1,210 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+const testAddress = "alice@example.com"
+
+func testSettings(source utils.MailSource, servers ...utils.MailServer) *utils.MailSettings {
+	settings := &utils.MailSettings{Source: source, EmailAddress: testAddress}
+	for _, s := range servers {
+		settings.Add(s)
+	}
+	return settings
+}
+
+var (
+	imapTLS      = utils.MailServer{Protocol: utils.MailIMAP, Hostname: "mail.example.com", Port: 993, Security: utils.SecurityTLS}
+	imapSTARTTLS = utils.MailServer{Protocol: utils.MailIMAP, Hostname: "mail.example.com", Port: 143, Security: utils.SecuritySTARTTLS}
+	pop3TLS      = utils.MailServer{Protocol: utils.MailPOP3, Hostname: "mail.example.com", Port: 995, Security: utils.SecurityTLS}
+	smtpTLS      = utils.MailServer{Protocol: utils.MailSMTP, Hostname: "smtp.example.com", Port: 465, Security: utils.SecurityTLS}
+)
+
+// s with the changes made by change
+func with(s utils.MailServer, change func(s *utils.MailServer)) utils.MailServer {
+	change(&s)
+	return s
+}
+
+func TestCompareMailSettings(t *testing.T) {
+	tests := []struct {
+		name       string
+		settings   map[utils.MailSource]*utils.MailSettings
+		precedence []utils.MailSource
+		conflicts  []string // direction and field
+		used       utils.MailSource
+		consistent bool
+	}{
+		{
+			name: "agree",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceSRV:        testSettings(utils.SourceSRV, imapTLS, smtpTLS),
+			},
+			used:       utils.SourceAutoconfig,
+			consistent: true,
+		},
+		{
+			name: "only the preferred servers are compared",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig:   testSettings(utils.SourceAutoconfig, imapTLS, pop3TLS, smtpTLS),
+				utils.SourceAutodiscover: testSettings(utils.SourceAutodiscover, imapTLS, imapSTARTTLS, smtpTLS),
+			},
+			used:       utils.SourceAutoconfig,
+			consistent: true,
+		},
+		{
+			name: "hostname, port and security",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceSRV:        testSettings(utils.SourceSRV, with(imapSTARTTLS, func(s *utils.MailServer) { s.Hostname = "imap.example.net" }), smtpTLS),
+			},
+			conflicts: []string{"incoming hostname", "incoming port", "incoming security"},
+			used:      utils.SourceAutoconfig,
+		},
+		{
+			// 993 and 995 both mean implicit TLS, they only differ because the protocols do
+			name: "port and security are not compared across protocols",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig:   testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceAutodiscover: testSettings(utils.SourceAutodiscover, with(pop3TLS, func(s *utils.MailServer) { s.Security = utils.SecuritySTARTTLS }), smtpTLS),
+			},
+			conflicts: []string{"incoming protocol"},
+			used:      utils.SourceAutoconfig,
+		},
+		{
+			name: "outgoing",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutodiscover: testSettings(utils.SourceAutodiscover, imapTLS, smtpTLS),
+				utils.SourceSRV:          testSettings(utils.SourceSRV, imapTLS, with(smtpTLS, func(s *utils.MailServer) { s.Port, s.Security = 587, utils.SecuritySTARTTLS })),
+			},
+			conflicts: []string{"outgoing port", "outgoing security"},
+			used:      utils.SourceAutodiscover,
+		},
+		{
+			name: "a missing username is the email address",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, with(imapTLS, func(s *utils.MailServer) { s.Username = testAddress }), smtpTLS),
+				utils.SourceSRV:        testSettings(utils.SourceSRV, imapTLS, smtpTLS),
+			},
+			used:       utils.SourceAutoconfig,
+			consistent: true,
+		},
+		{
+			name: "username",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig:   testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceAutodiscover: testSettings(utils.SourceAutodiscover, with(imapTLS, func(s *utils.MailServer) { s.Username = "alice" }), smtpTLS),
+			},
+			conflicts: []string{"incoming username"},
+			used:      utils.SourceAutoconfig,
+		},
+		{
+			name: "unknown security is left to the client",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig:   testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceAutodiscover: testSettings(utils.SourceAutodiscover, with(imapTLS, func(s *utils.MailServer) { s.Security = utils.SecurityUnknown }), smtpTLS),
+			},
+			used:       utils.SourceAutoconfig,
+			consistent: true,
+		},
+		{
+			name: "single source",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceSRV: testSettings(utils.SourceSRV, imapTLS, smtpTLS),
+			},
+			used: utils.SourceSRV,
+		},
+		{
+			name:     "none",
+			settings: map[utils.MailSource]*utils.MailSettings{},
+		},
+		{
+			name: "precedence picks the used source",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceSRV:        testSettings(utils.SourceSRV, imapTLS, smtpTLS),
+			},
+			precedence: []utils.MailSource{utils.SourceSRV, utils.SourceAutoconfig},
+			used:       utils.SourceSRV,
+			consistent: true,
+		},
+		{
+			name: "sources outside the precedence are ignored",
+			settings: map[utils.MailSource]*utils.MailSettings{
+				utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, imapTLS, smtpTLS),
+				utils.SourceSRV:        testSettings(utils.SourceSRV, pop3TLS, smtpTLS),
+				utils.SourceGuess:      testSettings(utils.SourceGuess, imapTLS, smtpTLS),
+			},
+			precedence: []utils.MailSource{utils.SourceGuess, utils.SourceAutoconfig},
+			used:       utils.SourceGuess,
+			consistent: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			precedence := test.precedence
+			if precedence == nil {
+				precedence = DefaultPrecedence
+			}
+			r := Compare_MailSettings(testAddress, test.settings, precedence)
+
+			conflicts := make([]string, 0)
+			for _, c := range r.Conflicts {
+				conflicts = append(conflicts, string(c.Direction)+" "+c.Field)
+				if len(c.Values) < 2 {
+					t.Errorf("%v: values %v", c, c.Values)
+				}
+			}
+			if test.conflicts == nil {
+				test.conflicts = []string{}
+			}
+			if !reflect.DeepEqual(conflicts, test.conflicts) {
+				t.Errorf("conflicts %q, want %q", conflicts, test.conflicts)
+			}
+			if r.Used != test.used || r.Consistent != test.consistent {
+				t.Errorf("used %q, consistent %v", r.Used, r.Consistent)
+			}
+			if len(r.Present)+len(r.Missing) != len(precedence) {
+				t.Errorf("present %v, missing %v", r.Present, r.Missing)
+			}
+		})
+	}
+}
+
+func TestConflictValues(t *testing.T) {
+	r := Compare_MailSettings(testAddress, map[utils.MailSource]*utils.MailSettings{
+		utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, imapTLS),
+		utils.SourceSRV:        testSettings(utils.SourceSRV, with(imapTLS, func(s *utils.MailServer) { s.Hostname = "imap.example.net" })),
+	}, DefaultPrecedence)
+
+	if len(r.Conflicts) != 1 {
+		t.Fatalf("conflicts %v", r.Conflicts)
+	}
+	want := "incoming hostname differs: autoconfig=mail.example.com, srv=imap.example.net"
+	if s := r.Conflicts[0].String(); s != want {
+		t.Errorf("%q, want %q", s, want)
+	}
+}
+
+func TestSummarizeConsistency(t *testing.T) {
+	reports := []*ConsistencyReport{
+		{Present: []utils.MailSource{utils.SourceAutoconfig, utils.SourceSRV}, Missing: []utils.MailSource{utils.SourceAutodiscover}, Used: utils.SourceAutoconfig, Consistent: true},
+		{Present: []utils.MailSource{utils.SourceAutoconfig, utils.SourceSRV}, Used: utils.SourceAutoconfig, Conflicts: []Conflict{{Field: "hostname"}, {Field: "port"}}},
+		{Present: []utils.MailSource{utils.SourceSRV}, Used: utils.SourceSRV},
+		{},
+		nil,
+	}
+	stats := Summarize_Consistency(reports)
+	if stats.Addresses != 4 || stats.Consistent != 1 || stats.Inconsistent != 1 || stats.Single != 1 || stats.None != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats.Used[utils.SourceAutoconfig] != 2 || stats.Used[utils.SourceSRV] != 1 || stats.Missing[utils.SourceAutodiscover] != 1 || stats.Conflicts["hostname"] != 1 {
+		t.Errorf("used %v, missing %v, conflicts %v", stats.Used, stats.Missing, stats.Conflicts)
+	}
+}
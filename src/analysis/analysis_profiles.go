@@ -0,0 +1,274 @@
+package analysis
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// how one mail client sets up an account: which mechanisms it asks, which candidates it tries and what it accepts.
+// Add a profile to `Profiles` to emulate another client.
+type ClientProfile struct {
+	Name                 string
+	Mechanisms           []utils.MailSource                                      // asked in this order, the first with settings is used
+	Autoconfig           func(urls []string) []string                            // select and order the urls of `autoconfig.Get_AutoconfigURLs`, nil keeps them
+	AutodiscoverBehavior autodiscover.ClientBehavior                             // the sequence `autodiscover.Get_AutodiscoverCandidates` generates
+	Autodiscover         func([]autodiscover.Candidate) []autodiscover.Candidate // select and order those candidates, nil keeps them
+	SRVServices          []string                                                // in the order the client prefers them, nil for `MailSRVServices` by priority and weight
+	Protocols            []utils.MailProtocol                                    // the protocols the client can use, nil for all
+	PreferIMAP           bool                                                    // IMAP before POP3, whatever order the source gives
+}
+
+const ispdbHost = "autoconfig.thunderbird.net"
+
+// the sequences as observed from the outside, built-in provider lists of the clients are not emulated
+var (
+	// Thunderbird's account setup: the domain's autoconfig, the ISPDB, the MX based candidates, then Exchange Autodiscover
+	ProfileThunderbird = &ClientProfile{
+		Name:                 "thunderbird",
+		Mechanisms:           []utils.MailSource{utils.SourceAutoconfig, utils.SourceAutodiscover},
+		AutodiscoverBehavior: autodiscover.BehaviorSpec,
+		PreferIMAP:           true,
+	}
+	// Outlook only speaks Autodiscover and tries Office 365 first
+	ProfileOutlook = &ClientProfile{
+		Name:                 "outlook",
+		Mechanisms:           []utils.MailSource{utils.SourceAutodiscover},
+		AutodiscoverBehavior: autodiscover.BehaviorOutlook,
+	}
+	// Apple Mail asks Autodiscover over https and falls back to the RFC 6186 records, preferring implicit TLS
+	ProfileAppleMail = &ClientProfile{
+		Name:                 "applemail",
+		Mechanisms:           []utils.MailSource{utils.SourceAutodiscover, utils.SourceSRV},
+		AutodiscoverBehavior: autodiscover.BehaviorSpec,
+		Autodiscover:         httpsCandidates,
+		SRVServices:          []string{"imaps", "imap", "submissions", "submission", "pop3s", "pop3"},
+		PreferIMAP:           true,
+	}
+	// K-9 Mail and Thunderbird for Android ask the ISPDB first and never use plain HTTP
+	ProfileK9 = &ClientProfile{
+		Name:       "k9",
+		Mechanisms: []utils.MailSource{utils.SourceAutoconfig},
+		Autoconfig: func(urls []string) []string { return ispdbFirst(httpsURLs(urls)) },
+		PreferIMAP: true,
+	}
+	// the Gmail app only knows providers from its list (the ISPDB stands in for it) and Exchange servers,
+	// and adds other accounts over IMAP only, like its iOS version
+	ProfileGmail = &ClientProfile{
+		Name:                 "gmail",
+		Mechanisms:           []utils.MailSource{utils.SourceAutoconfig, utils.SourceAutodiscover},
+		Autoconfig:           ispdbOnly,
+		AutodiscoverBehavior: autodiscover.BehaviorSpec,
+		Autodiscover:         httpsCandidates,
+		Protocols:            []utils.MailProtocol{utils.MailIMAP, utils.MailSMTP},
+	}
+)
+
+var Profiles = []*ClientProfile{ProfileThunderbird, ProfileOutlook, ProfileAppleMail, ProfileK9, ProfileGmail}
+
+// the profile in `Profiles` called name, nil if there is none
+func Find_Profile(name string) *ClientProfile {
+	for _, p := range Profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// what a client ends up with
+type ClientResult struct {
+	Profile    string
+	Used       utils.MailSource    // "" if the client found nothing it can use
+	Settings   *utils.MailSettings // restricted and ordered the way the client would use them, nil if Used is ""
+	Collection *Collection         // what the mechanisms the client asked returned
+}
+
+// run the mechanisms of profile for email_address in its order, until one yields settings the client can use
+func Emulate_Client(email_address string, suffixlistpath string, profile *ClientProfile) (*ClientResult, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ClientResult{Profile: profile.Name}
+	c := &Collection{
+		EmailAddress: email_address,
+		Settings:     make(map[utils.MailSource]*utils.MailSettings),
+		Errors:       make(map[utils.MailSource]error),
+	}
+	result.Collection = c
+
+	for _, source := range profile.Mechanisms {
+		switch source {
+		case utils.SourceAutoconfig:
+			urls := autoconfig.Get_AutoconfigURLs(addr, suffixlistpath)
+			if profile.Autoconfig != nil {
+				urls = profile.Autoconfig(urls)
+			}
+			if d, err := autoconfig.Discover_AutoconfigFrom(email_address, urls); err != nil {
+				c.Errors[source] = err
+			} else {
+				c.Autoconfig = d
+				c.addAutoconfig(d)
+			}
+		case utils.SourceAutodiscover:
+			candidates := autodiscover.Get_AutodiscoverCandidates(addr, profile.AutodiscoverBehavior)
+			if profile.Autodiscover != nil {
+				candidates = profile.Autodiscover(candidates)
+			}
+			if d, err := autodiscover.Discover_Candidates(email_address, candidates); err != nil {
+				c.Errors[source] = err
+			} else {
+				c.Autodiscover = d
+				c.add(source, d.MailSettings())
+			}
+		case utils.SourceSRV:
+			services := profile.SRVServices
+			if services == nil {
+				services = MailSRVServices
+			}
+			c.SRV, c.SRVDNSSEC, err = lookupSRVServices(addr.ASCIIDomain, services)
+			if err != nil {
+				c.Errors[source] = err
+			} else if profile.SRVServices == nil {
+				c.add(source, utils.MailSettings_FromSRV(email_address, c.SRV))
+			} else {
+				c.add(source, srvSettingsByService(email_address, c.SRV, profile.SRVServices))
+			}
+		default:
+			c.Errors[source] = fmt.Errorf("unknown mechanism %q", source)
+		}
+
+		if c.Settings[source] == nil {
+			continue
+		}
+		settings := profile.restrict(c.Settings[source])
+		if len(settings.Incoming) > 0 || len(settings.Outgoing) > 0 {
+			result.Used = source
+			result.Settings = settings
+			break
+		}
+		c.Errors[source] = fmt.Errorf("no server %v can use in the %v settings", profile.Name, source)
+	}
+
+	return result, nil
+}
+
+// emulate every profile, `Profiles` if profiles is nil
+func Emulate_Clients(email_address string, suffixlistpath string, profiles []*ClientProfile) ([]*ClientResult, error) {
+	if profiles == nil {
+		profiles = Profiles
+	}
+	results := make([]*ClientResult, 0, len(profiles))
+	for _, p := range profiles {
+		r, err := Emulate_Client(email_address, suffixlistpath, p)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// the servers of settings the client can use, in the order it would try them
+func (p *ClientProfile) restrict(settings *utils.MailSettings) *utils.MailSettings {
+	restricted := &utils.MailSettings{Source: settings.Source, EmailAddress: settings.EmailAddress, Unavailable: settings.Unavailable}
+	for _, s := range append(append([]utils.MailServer{}, settings.Incoming...), settings.Outgoing...) {
+		if p.supports(s.Protocol) {
+			restricted.Add(s)
+		}
+	}
+	if p.PreferIMAP {
+		sort.SliceStable(restricted.Incoming, func(i, j int) bool {
+			return restricted.Incoming[i].Protocol == utils.MailIMAP && restricted.Incoming[j].Protocol != utils.MailIMAP
+		})
+	}
+	return restricted
+}
+
+// like `utils.MailSettings_FromSRV`, but the servers of a service come before those of the services after it,
+// priority and weight only order the servers of the same service
+func srvSettingsByService(email_address string, targets []utils.SRVTarget, services []string) *utils.MailSettings {
+	settings := &utils.MailSettings{Source: utils.SourceSRV, EmailAddress: email_address}
+	for _, service := range services {
+		of := make([]utils.SRVTarget, 0)
+		for _, t := range targets {
+			if t.Service == "_"+service+"._tcp" {
+				of = append(of, t)
+			}
+		}
+		s := utils.MailSettings_FromSRV(email_address, of)
+		settings.Incoming = append(settings.Incoming, s.Incoming...)
+		settings.Outgoing = append(settings.Outgoing, s.Outgoing...)
+		settings.Unavailable = append(settings.Unavailable, s.Unavailable...)
+	}
+	return settings
+}
+
+func (p *ClientProfile) supports(protocol utils.MailProtocol) bool {
+	if p.Protocols == nil {
+		return true
+	}
+	for _, supported := range p.Protocols {
+		if supported == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func httpsURLs(urls []string) []string {
+	kept := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if strings.HasPrefix(u, "https://") {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+func isISPDB(rawurl string) bool {
+	return strings.HasPrefix(rawurl, "https://"+ispdbHost+"/")
+}
+
+func ispdbFirst(urls []string) []string {
+	ordered := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if isISPDB(u) {
+			ordered = append(ordered, u)
+		}
+	}
+	for _, u := range urls {
+		if !isISPDB(u) {
+			ordered = append(ordered, u)
+		}
+	}
+	return ordered
+}
+
+func ispdbOnly(urls []string) []string {
+	kept := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if isISPDB(u) {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+// candidates POSTed over https, without the plain HTTP redirect method of MS-OXDISCO 3.1.5.4
+func httpsCandidates(candidates []autodiscover.Candidate) []autodiscover.Candidate {
+	kept := make([]autodiscover.Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if c.Method == http.MethodPost && strings.HasPrefix(c.URL, "https://") {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
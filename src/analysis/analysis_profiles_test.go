@@ -0,0 +1,174 @@
+package analysis
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// the candidates of `autoconfig.Get_AutoconfigURLs` for alice@example.com with an MX at mx.example.net
+var testAutoconfigURLs = []string{
+	"https://autoconfig.example.com/mail/config-v1.1.xml?emailaddress=alice%40example.com",
+	"https://example.com/.well-known/autoconfig/mail/config-v1.1.xml",
+	"http://autoconfig.example.com/mail/config-v1.1.xml",
+	"https://autoconfig.thunderbird.net/v1.1/example.com",
+	"https://autoconfig.mx.example.net/mail/config-v1.1.xml?emailaddress=alice%40example.com",
+	"https://autoconfig.example.net/mail/config-v1.1.xml?emailaddress=alice%40example.com",
+	"https://autoconfig.thunderbird.net/v1.1/example.net",
+}
+
+var testAutodiscoverCandidates = []autodiscover.Candidate{
+	{URL: "https://example.com/autodiscover/autodiscover.xml", Method: http.MethodPost, Section: "3.1.5.1"},
+	{URL: "https://autodiscover.example.com/autodiscover/autodiscover.xml", Method: http.MethodPost, Section: "3.1.5.2"},
+	{URL: "http://autodiscover.example.com/autodiscover/autodiscover.xml", Method: http.MethodGet, Section: "3.1.5.4"},
+	{URL: "https://mail.example.com/autodiscover/autodiscover.xml", Method: http.MethodPost, Section: "3.1.5.3"},
+	{URL: "http://example.com/autodiscover/autodiscover.xml", Method: http.MethodPost, Section: "legacy"},
+}
+
+// settings as a source could return them, POP3 first
+func testSourceSettings() *utils.MailSettings {
+	return &utils.MailSettings{
+		Source:       utils.SourceAutoconfig,
+		EmailAddress: testAddress,
+		Incoming:     []utils.MailServer{pop3TLS, imapSTARTTLS, imapTLS},
+		Outgoing:     []utils.MailServer{smtpTLS},
+		Unavailable:  []string{"_pop3._tcp"},
+	}
+}
+
+func candidateURLs(candidates []autodiscover.Candidate) []string {
+	urls := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		urls = append(urls, c.URL)
+	}
+	return urls
+}
+
+func serverNames(servers []utils.MailServer) []string {
+	names := make([]string, 0, len(servers))
+	for _, s := range servers {
+		names = append(names, s.String())
+	}
+	return names
+}
+
+// each profile filters and orders the same candidates and servers its own way
+func TestProfiles(t *testing.T) {
+	all := testAutoconfigURLs
+	allCandidates := candidateURLs(testAutodiscoverCandidates)
+	httpsCandidates := []string{allCandidates[0], allCandidates[1], allCandidates[3]}
+	imapFirst := serverNames([]utils.MailServer{imapSTARTTLS, imapTLS, pop3TLS})
+	asGiven := serverNames(testSourceSettings().Incoming)
+
+	tests := []struct {
+		profile      *ClientProfile
+		autoconfig   []string
+		autodiscover []string
+		incoming     []string
+	}{
+		{ProfileThunderbird, all, allCandidates, imapFirst},
+		{ProfileOutlook, all, allCandidates, asGiven},
+		{ProfileAppleMail, all, httpsCandidates, imapFirst},
+		// the ISPDB first, without the plain HTTP url
+		{ProfileK9, []string{all[3], all[6], all[0], all[1], all[4], all[5]}, allCandidates, imapFirst},
+		{ProfileGmail, []string{all[3], all[6]}, httpsCandidates, serverNames([]utils.MailServer{imapSTARTTLS, imapTLS})},
+	}
+	if len(tests) != len(Profiles) {
+		t.Errorf("%v profiles, %v tested", len(Profiles), len(tests))
+	}
+
+	for _, test := range tests {
+		t.Run(test.profile.Name, func(t *testing.T) {
+			p := test.profile
+			if Find_Profile(p.Name) != p {
+				t.Errorf("Find_Profile(%q) didn't find it", p.Name)
+			}
+
+			urls := append([]string{}, testAutoconfigURLs...)
+			if p.Autoconfig != nil {
+				urls = p.Autoconfig(urls)
+			}
+			if !reflect.DeepEqual(urls, test.autoconfig) {
+				t.Errorf("autoconfig urls\n%q, want\n%q", urls, test.autoconfig)
+			}
+
+			candidates := append([]autodiscover.Candidate{}, testAutodiscoverCandidates...)
+			if p.Autodiscover != nil {
+				candidates = p.Autodiscover(candidates)
+			}
+			if got := candidateURLs(candidates); !reflect.DeepEqual(got, test.autodiscover) {
+				t.Errorf("autodiscover candidates\n%q, want\n%q", got, test.autodiscover)
+			}
+
+			settings := p.restrict(testSourceSettings())
+			if got := serverNames(settings.Incoming); !reflect.DeepEqual(got, test.incoming) {
+				t.Errorf("incoming %q, want %q", got, test.incoming)
+			}
+			if got := serverNames(settings.Outgoing); !reflect.DeepEqual(got, serverNames([]utils.MailServer{smtpTLS})) {
+				t.Errorf("outgoing %q", got)
+			}
+			if !reflect.DeepEqual(settings.Unavailable, []string{"_pop3._tcp"}) || settings.Source != utils.SourceAutoconfig || settings.EmailAddress != testAddress {
+				t.Errorf("settings %+v", settings)
+			}
+		})
+	}
+}
+
+func TestRestrictProtocols(t *testing.T) {
+	source := testSourceSettings()
+	pop3Only := &ClientProfile{Name: "pop3", Protocols: []utils.MailProtocol{utils.MailPOP3}, PreferIMAP: true}
+	settings := pop3Only.restrict(source)
+	if !reflect.DeepEqual(settings.Incoming, []utils.MailServer{pop3TLS}) || len(settings.Outgoing) != 0 {
+		t.Errorf("restricted to %+v", settings)
+	}
+
+	// the source's settings are left alone
+	if !reflect.DeepEqual(source, testSourceSettings()) {
+		t.Errorf("source changed to %+v", source)
+	}
+
+	if (&ClientProfile{Protocols: []utils.MailProtocol{utils.MailSMTP}}).supports(utils.MailIMAP) || !(&ClientProfile{}).supports(utils.MailPOP3) {
+		t.Error("supports")
+	}
+}
+
+func TestSRVSettingsByService(t *testing.T) {
+	targets := []utils.SRVTarget{
+		{Service: "_imap._tcp", Target: "imap.example.com", Port: 143, Priority: 0},
+		{Service: "_imaps._tcp", Target: "imaps2.example.com", Port: 993, Priority: 20},
+		{Service: "_imaps._tcp", Target: "imaps1.example.com", Port: 993, Priority: 10},
+		{Service: "_submission._tcp", Target: "smtp.example.com", Port: 587, Priority: 0},
+		{Service: "_submissions._tcp", Target: "smtps.example.com", Port: 465, Priority: 5},
+		{Service: "_pop3s._tcp", Target: ".", Port: 0},
+		{Service: "_pop3._tcp", Target: "pop.example.com", Port: 110},
+	}
+
+	// by priority alone the STARTTLS servers of priority 0 come first, whatever their service
+	bySRV := utils.MailSettings_FromSRV(testAddress, targets)
+	want := []string{"imap imap.example.com:143 (starttls)", "pop3 pop.example.com:110 (starttls)", "imap imaps1.example.com:993 (tls)", "imap imaps2.example.com:993 (tls)"}
+	if got := serverNames(bySRV.Incoming); !reflect.DeepEqual(got, want) {
+		t.Errorf("by priority %q, want %q", got, want)
+	}
+
+	settings := srvSettingsByService(testAddress, targets, ProfileAppleMail.SRVServices)
+	want = []string{"imap imaps1.example.com:993 (tls)", "imap imaps2.example.com:993 (tls)", "imap imap.example.com:143 (starttls)", "pop3 pop.example.com:110 (starttls)"}
+	if got := serverNames(settings.Incoming); !reflect.DeepEqual(got, want) {
+		t.Errorf("incoming %q, want %q", got, want)
+	}
+	want = []string{"smtp smtps.example.com:465 (tls)", "smtp smtp.example.com:587 (starttls)"}
+	if got := serverNames(settings.Outgoing); !reflect.DeepEqual(got, want) {
+		t.Errorf("outgoing %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(settings.Unavailable, []string{"_pop3s._tcp"}) || settings.Source != utils.SourceSRV {
+		t.Errorf("settings %+v", settings)
+	}
+
+	// services the profile doesn't ask for are left out
+	settings = srvSettingsByService(testAddress, targets, []string{"imaps", "submissions"})
+	if len(settings.Incoming) != 2 || len(settings.Outgoing) != 1 || len(settings.Unavailable) != 0 {
+		t.Errorf("settings %+v", settings)
+	}
+}
@@ -0,0 +1,363 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/probe"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+type Grade string
+
+const (
+	GradeA Grade = "A"
+	GradeB Grade = "B"
+	GradeC Grade = "C"
+	GradeD Grade = "D"
+	GradeF Grade = "F"
+)
+
+// reason codes, see `Penalties`
+const (
+	ReasonNoConfiguration    = "no-configuration"
+	ReasonPlaintext          = "plaintext"          // a server without any TLS
+	ReasonSTARTTLS           = "starttls"           // STARTTLS can be stripped, RFC 8314 prefers implicit TLS
+	ReasonUnknownSecurity    = "unknown-security"   // the source leaves the security to the client
+	ReasonCleartextPassword  = "cleartext-password" // a password sent over a plaintext connection
+	ReasonUnknownAuth        = "unknown-auth"       // a plaintext connection whose source doesn't say how the password is sent
+	ReasonHTTPDiscovery      = "http-discovery"     // the settings were fetched over plain HTTP
+	ReasonInsecureRedirect   = "insecure-redirect"  // a discovery redirect to a plain HTTP url
+	ReasonThirdPartySRV      = "third-party-srv"    // RFC 6186 section 6
+	ReasonTLSFailed          = "tls-failed"         // no TLS handshake with an endpoint that should have TLS
+	ReasonInvalidCertificate = "invalid-certificate"
+	ReasonHostnameMismatch   = "hostname-mismatch"
+	ReasonExpiredCertificate = "expired-certificate"
+	ReasonOldTLS             = "old-tls" // TLS 1.0 or 1.1 is still accepted
+)
+
+// points taken off 100 for each occurrence of a reason, change to weigh reasons differently
+var Penalties = map[string]int{
+	ReasonNoConfiguration:    100,
+	ReasonPlaintext:          30,
+	ReasonSTARTTLS:           5,
+	ReasonUnknownSecurity:    5,
+	ReasonCleartextPassword:  40,
+	ReasonUnknownAuth:        10,
+	ReasonHTTPDiscovery:      20,
+	ReasonInsecureRedirect:   15,
+	ReasonThirdPartySRV:      10,
+	ReasonTLSFailed:          25,
+	ReasonInvalidCertificate: 25,
+	ReasonHostnameMismatch:   25,
+	ReasonExpiredCertificate: 10,
+	ReasonOldTLS:             5,
+}
+
+// the best grade a domain can get while it has the reason, whatever its score
+var GradeCaps = map[string]Grade{
+	ReasonNoConfiguration:    GradeF,
+	ReasonCleartextPassword:  GradeF,
+	ReasonPlaintext:          GradeC,
+	ReasonHTTPDiscovery:      GradeC,
+	ReasonInvalidCertificate: GradeC,
+	ReasonHostnameMismatch:   GradeC,
+}
+
+// one itemized reason for a score
+type Reason struct {
+	Code    string
+	Source  utils.MailSource // the mechanism the reason comes from, "" if it is about an endpoint all of them share
+	Penalty int
+	Message string
+}
+
+func (r Reason) String() string {
+	if r.Source == "" {
+		return fmt.Sprintf("-%d %v: %v", r.Penalty, r.Code, r.Message)
+	}
+	return fmt.Sprintf("-%d %v (%v): %v", r.Penalty, r.Code, r.Source, r.Message)
+}
+
+// the transport security rating of the configuration discoverable for one email address
+type DomainScore struct {
+	Domain       string
+	EmailAddress string
+	Time         time.Time
+	Score        int // 0 - 100
+	Grade        Grade
+	Reasons      []Reason
+}
+
+// how a domain's score changed between two runs
+type ScoreChange struct {
+	Domain  string
+	Before  *DomainScore
+	After   *DomainScore
+	Delta   int
+	Added   []Reason // reasons only the later score has
+	Removed []Reason // reasons only the earlier score has
+}
+
+type ScoreStats struct {
+	Domains  int
+	Mean     float64
+	ByGrade  map[Grade]int
+	ByReason map[string]int // domains with the reason
+}
+
+// collect, inspect and score the configuration of email_address
+func Analyze_Score(email_address string, suffixlistpath string) (*DomainScore, error) {
+	c, err := Collect_MailSettings(email_address, suffixlistpath, autodiscover.BehaviorSpec)
+	if err != nil {
+		return nil, err
+	}
+	return Score_Collection(c, Inspect_Collection(c, probe.New_Inspector())), nil
+}
+
+// inspect the TLS of every mail server that should have TLS and of every https discovery url, once per endpoint
+func Inspect_Collection(c *Collection, inspector *probe.Inspector) []*probe.TLSInspection {
+	endpoints := make([]probe.Endpoint, 0)
+	seen := make(map[string]bool)
+	add := func(e probe.Endpoint) {
+		if !seen[e.String()] {
+			seen[e.String()] = true
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	for _, source := range DefaultPrecedence {
+		settings := c.Settings[source]
+		if settings == nil {
+			continue
+		}
+		for _, s := range append(append([]utils.MailServer{}, settings.Incoming...), settings.Outgoing...) {
+			if s.Security == utils.SecurityPlain {
+				continue
+			}
+			if e, ok := probe.Endpoint_FromMailServer(s); ok {
+				add(e)
+			}
+		}
+	}
+	for _, rawurl := range discoveryURLs(c) {
+		if e, err := probe.Endpoint_FromURL(rawurl); err == nil {
+			add(e)
+		}
+	}
+
+	inspections := make([]*probe.TLSInspection, 0, len(endpoints))
+	for _, e := range endpoints {
+		inspections = append(inspections, inspector.Inspect(e))
+	}
+	return inspections
+}
+
+// rate the collected configuration, inspections may be nil to leave certificates out
+func Score_Collection(c *Collection, inspections []*probe.TLSInspection) *DomainScore {
+	score := &DomainScore{EmailAddress: c.EmailAddress, Time: time.Now()}
+	if addr, err := utils.Parse_EmailAddress(c.EmailAddress); err == nil {
+		score.Domain = addr.ASCIIDomain
+	}
+	reason := func(code string, source utils.MailSource, format string, args ...interface{}) {
+		score.Reasons = append(score.Reasons, Reason{Code: code, Source: source, Penalty: Penalties[code], Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(c.Settings) == 0 {
+		reason(ReasonNoConfiguration, "", "no mechanism returned IMAP, POP3 or SMTP settings")
+	}
+
+	// a server several sources agree on counts once
+	seen := make(map[string]bool)
+	for _, source := range DefaultPrecedence {
+		settings := c.Settings[source]
+		if settings == nil {
+			continue
+		}
+		for _, s := range append(append([]utils.MailServer{}, settings.Incoming...), settings.Outgoing...) {
+			if seen[s.String()] {
+				continue
+			}
+			seen[s.String()] = true
+
+			switch s.Security {
+			case utils.SecurityPlain:
+				reason(ReasonPlaintext, source, "%v", s)
+				switch s.Authentication {
+				case "password-cleartext":
+					reason(ReasonCleartextPassword, source, "%v sends the password unencrypted", s)
+				case "":
+					reason(ReasonUnknownAuth, source, "%v may send the password unencrypted", s)
+				}
+			case utils.SecuritySTARTTLS:
+				reason(ReasonSTARTTLS, source, "%v", s)
+			case utils.SecurityUnknown:
+				reason(ReasonUnknownSecurity, source, "%v", s)
+			}
+		}
+	}
+
+	if c.Autoconfig != nil {
+		if strings.HasPrefix(c.Autoconfig.FinalURL, "http://") {
+			reason(ReasonHTTPDiscovery, utils.SourceAutoconfig, "config from %v", c.Autoconfig.FinalURL)
+		}
+		for _, r := range c.Autoconfig.Redirects {
+			if strings.HasPrefix(r, "http://") {
+				reason(ReasonInsecureRedirect, utils.SourceAutoconfig, "redirect to %v", r)
+			}
+		}
+	}
+	if c.Autodiscover != nil {
+		if strings.HasPrefix(c.Autodiscover.URL, "http://") {
+			reason(ReasonHTTPDiscovery, utils.SourceAutodiscover, "settings from %v", c.Autodiscover.URL)
+		}
+		for _, r := range c.Autodiscover.Redirects {
+			if strings.HasPrefix(r, "http://") {
+				reason(ReasonInsecureRedirect, utils.SourceAutodiscover, "redirect to %v", r)
+			}
+		}
+	}
+	for _, t := range c.SRV {
+		if t.Class == utils.SRVThirdParty {
+			reason(ReasonThirdPartySRV, utils.SourceSRV, "%v.%v points to %v (%v)", t.Service, t.Domain, t.Target, t.DNSSEC)
+		}
+	}
+
+	for _, i := range inspections {
+		switch {
+		case i == nil:
+			continue
+		case i.Error != "":
+			reason(ReasonTLSFailed, "", "%v: %v", i.Endpoint, i.Error)
+			continue
+		}
+		// an expired certificate that is otherwise valid is only charged as expired
+		if !i.Verified && !i.OnlyExpired {
+			reason(ReasonInvalidCertificate, "", "%v: %v", i.Endpoint, i.VerifyError)
+		}
+		if !i.HostnameMatch {
+			reason(ReasonHostnameMismatch, "", "%v: %v", i.Endpoint, i.HostnameError)
+		}
+		if i.Expired {
+			reason(ReasonExpiredCertificate, "", "%v", i.Endpoint)
+		}
+		for _, v := range i.SupportedVersions {
+			if v == "TLS 1.0" || v == "TLS 1.1" {
+				reason(ReasonOldTLS, "", "%v accepts %v", i.Endpoint, v)
+			}
+		}
+	}
+
+	score.Score = 100
+	for _, r := range score.Reasons {
+		score.Score -= r.Penalty
+	}
+	if score.Score < 0 {
+		score.Score = 0
+	}
+	score.Grade = gradeOf(score.Score)
+	// grades compare as strings, later letters are worse
+	for _, r := range score.Reasons {
+		if limit, ok := GradeCaps[r.Code]; ok && limit > score.Grade {
+			score.Grade = limit
+		}
+	}
+	return score
+}
+
+func gradeOf(score int) Grade {
+	switch {
+	case score >= 90:
+		return GradeA
+	case score >= 80:
+		return GradeB
+	case score >= 65:
+		return GradeC
+	case score >= 50:
+		return GradeD
+	}
+	return GradeF
+}
+
+// the urls the settings were fetched from and redirected through
+func discoveryURLs(c *Collection) []string {
+	urls := make([]string, 0)
+	if c.Autoconfig != nil {
+		for _, r := range c.Autoconfig.Redirects {
+			if strings.HasPrefix(r, "https://") {
+				urls = append(urls, r)
+			}
+		}
+		urls = append(urls, c.Autoconfig.FinalURL)
+	}
+	if c.Autodiscover != nil {
+		for _, r := range c.Autodiscover.Redirects {
+			if strings.HasPrefix(r, "https://") {
+				urls = append(urls, r)
+			}
+		}
+		urls = append(urls, c.Autodiscover.URL)
+	}
+	return urls
+}
+
+// sort scores best first, ties by domain
+func Rank_Scores(scores []*DomainScore) {
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Domain < scores[j].Domain
+	})
+}
+
+// what changed between an earlier and a later score of the same domain
+func Compare_Scores(before *DomainScore, after *DomainScore) ScoreChange {
+	change := ScoreChange{Domain: after.Domain, Before: before, After: after, Delta: after.Score - before.Score}
+	change.Added = reasonsMissingFrom(after.Reasons, before.Reasons)
+	change.Removed = reasonsMissingFrom(before.Reasons, after.Reasons)
+	return change
+}
+
+func reasonsMissingFrom(reasons []Reason, other []Reason) []Reason {
+	have := make(map[string]bool)
+	for _, r := range other {
+		have[r.Code+" "+r.Message] = true
+	}
+	missing := make([]Reason, 0)
+	for _, r := range reasons {
+		if !have[r.Code+" "+r.Message] {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// aggregate the results of `Score_Collection`
+func Summarize_Scores(scores []*DomainScore) ScoreStats {
+	stats := ScoreStats{ByGrade: make(map[Grade]int), ByReason: make(map[string]int)}
+	total := 0
+	for _, s := range scores {
+		if s == nil {
+			continue
+		}
+		stats.Domains++
+		total += s.Score
+		stats.ByGrade[s.Grade]++
+
+		codes := make(map[string]bool)
+		for _, r := range s.Reasons {
+			codes[r.Code] = true
+		}
+		for code := range codes {
+			stats.ByReason[code]++
+		}
+	}
+	if stats.Domains > 0 {
+		stats.Mean = float64(total) / float64(stats.Domains)
+	}
+	return stats
+}
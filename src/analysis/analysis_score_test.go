@@ -0,0 +1,280 @@
+package analysis
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/probe"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+var imapPlain = utils.MailServer{Protocol: utils.MailIMAP, Hostname: "mail.example.com", Port: 143, Security: utils.SecurityPlain, Authentication: "password-cleartext"}
+
+// a collection with the servers from autoconfig, fetched from its https candidate
+func testCollection(servers ...utils.MailServer) *Collection {
+	return &Collection{
+		EmailAddress: testAddress,
+		Autoconfig:   &autoconfig.Discovery{URL: "https://autoconfig.example.com/mail/config-v1.1.xml", FinalURL: "https://autoconfig.example.com/mail/config-v1.1.xml"},
+		Settings:     map[utils.MailSource]*utils.MailSettings{utils.SourceAutoconfig: testSettings(utils.SourceAutoconfig, servers...)},
+	}
+}
+
+// an inspection with a valid certificate
+func testInspection(change func(i *probe.TLSInspection)) *probe.TLSInspection {
+	i := &probe.TLSInspection{
+		Endpoint:          probe.Endpoint{Protocol: probe.ProtocolIMAP, Host: "mail.example.com", Port: 993, ImplicitTLS: true},
+		Version:           "TLS 1.3",
+		SupportedVersions: []string{"TLS 1.2", "TLS 1.3"},
+		Verified:          true,
+		HostnameMatch:     true,
+	}
+	change(i)
+	return i
+}
+
+func TestScoreCollection(t *testing.T) {
+	tests := []struct {
+		name        string
+		collection  *Collection
+		inspections []*probe.TLSInspection
+		reasons     []string // codes, sorted
+		score       int
+		grade       Grade
+	}{
+		{
+			name:       "no configuration",
+			collection: &Collection{EmailAddress: testAddress},
+			reasons:    []string{ReasonNoConfiguration},
+			score:      0,
+			grade:      GradeF,
+		},
+		{
+			name:        "implicit TLS",
+			collection:  testCollection(imapTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) {})},
+			score:       100,
+			grade:       GradeA,
+		},
+		{
+			name:       "STARTTLS",
+			collection: testCollection(imapSTARTTLS, smtpTLS),
+			reasons:    []string{ReasonSTARTTLS},
+			score:      95,
+			grade:      GradeA,
+		},
+		{
+			name:       "unknown security",
+			collection: testCollection(with(imapTLS, func(s *utils.MailServer) { s.Security = utils.SecurityUnknown }), smtpTLS),
+			reasons:    []string{ReasonUnknownSecurity},
+			score:      95,
+			grade:      GradeA,
+		},
+		{
+			name:       "plaintext with a cleartext password",
+			collection: testCollection(imapPlain, smtpTLS),
+			reasons:    []string{ReasonCleartextPassword, ReasonPlaintext},
+			score:      30,
+			grade:      GradeF,
+		},
+		{
+			name:       "plaintext with an encrypted password",
+			collection: testCollection(with(imapPlain, func(s *utils.MailServer) { s.Authentication = "password-encrypted" }), smtpTLS),
+			reasons:    []string{ReasonPlaintext},
+			score:      70,
+			grade:      GradeC,
+		},
+		{
+			name:       "plaintext without authentication",
+			collection: testCollection(with(imapPlain, func(s *utils.MailServer) { s.Authentication = "" }), smtpTLS),
+			reasons:    []string{ReasonPlaintext, ReasonUnknownAuth},
+			score:      60,
+			grade:      GradeD,
+		},
+		{
+			name: "a server several sources agree on counts once",
+			collection: func() *Collection {
+				c := testCollection(imapSTARTTLS, smtpTLS)
+				c.Settings[utils.SourceSRV] = testSettings(utils.SourceSRV, imapSTARTTLS, smtpTLS)
+				return c
+			}(),
+			reasons: []string{ReasonSTARTTLS},
+			score:   95,
+			grade:   GradeA,
+		},
+		{
+			// 80 would be a B
+			name: "autoconfig over HTTP is capped at C",
+			collection: func() *Collection {
+				c := testCollection(imapTLS, smtpTLS)
+				c.Autoconfig.URL, c.Autoconfig.FinalURL = "http://autoconfig.example.com/mail/config-v1.1.xml", "http://autoconfig.example.com/mail/config-v1.1.xml"
+				return c
+			}(),
+			reasons: []string{ReasonHTTPDiscovery},
+			score:   80,
+			grade:   GradeC,
+		},
+		{
+			name: "autoconfig redirected to HTTP",
+			collection: func() *Collection {
+				c := testCollection(imapTLS, smtpTLS)
+				c.Autoconfig.FinalURL = "http://mail.example.net/config.xml"
+				c.Autoconfig.Redirects = []string{"http://mail.example.net/config.xml"}
+				return c
+			}(),
+			reasons: []string{ReasonHTTPDiscovery, ReasonInsecureRedirect},
+			score:   65,
+			grade:   GradeC,
+		},
+		{
+			name: "autoconfig redirected through HTTP back to HTTPS",
+			collection: func() *Collection {
+				c := testCollection(imapTLS, smtpTLS)
+				c.Autoconfig.FinalURL = "https://mail.example.net/config.xml"
+				c.Autoconfig.Redirects = []string{"http://mail.example.net/config.xml", "https://mail.example.net/config.xml"}
+				return c
+			}(),
+			reasons: []string{ReasonInsecureRedirect},
+			score:   85,
+			grade:   GradeB,
+		},
+		{
+			name: "Autodiscover over HTTP with an insecure redirect",
+			collection: func() *Collection {
+				c := testCollection(imapTLS, smtpTLS)
+				c.Autodiscover = &autodiscover.Discovery{URL: "http://autodiscover.example.com/autodiscover/autodiscover.xml", Redirects: []string{"http://autodiscover.example.com/autodiscover/autodiscover.xml"}}
+				return c
+			}(),
+			reasons: []string{ReasonHTTPDiscovery, ReasonInsecureRedirect},
+			score:   65,
+			grade:   GradeC,
+		},
+		{
+			name: "third party SRV target",
+			collection: func() *Collection {
+				c := testCollection(imapTLS, smtpTLS)
+				c.SRV = []utils.SRVTarget{
+					{Service: "_imaps._tcp", Domain: "example.com", Target: "mail.example.com", Port: 993, Class: utils.SRVInDomain},
+					{Service: "_submissions._tcp", Domain: "example.com", Target: "smtp.example.net", Port: 465, Class: utils.SRVThirdParty},
+				}
+				return c
+			}(),
+			reasons: []string{ReasonThirdPartySRV},
+			score:   90,
+			grade:   GradeA,
+		},
+		{
+			name:       "expired, but otherwise valid certificate",
+			collection: testCollection(imapTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) {
+				i.Verified, i.VerifyError, i.OnlyExpired, i.Expired = false, "x509: certificate has expired", true, true
+			})},
+			reasons: []string{ReasonExpiredCertificate},
+			score:   90,
+			grade:   GradeA,
+		},
+		{
+			name:       "expired certificate from an unknown CA",
+			collection: testCollection(imapTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) {
+				i.Verified, i.VerifyError, i.Expired = false, "x509: certificate signed by unknown authority", true
+			})},
+			reasons: []string{ReasonExpiredCertificate, ReasonInvalidCertificate},
+			score:   65,
+			grade:   GradeC,
+		},
+		{
+			name:       "hostname mismatch",
+			collection: testCollection(imapTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) {
+				i.HostnameMatch, i.HostnameError = false, "x509: certificate is valid for mail.example.net, not mail.example.com"
+			})},
+			reasons: []string{ReasonHostnameMismatch},
+			score:   75,
+			grade:   GradeC,
+		},
+		{
+			name:       "hostname mismatch and STARTTLS",
+			collection: testCollection(imapSTARTTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) {
+				i.HostnameMatch, i.HostnameError = false, "x509: certificate is valid for mail.example.net, not mail.example.com"
+			})},
+			reasons: []string{ReasonHostnameMismatch, ReasonSTARTTLS},
+			score:   70,
+			grade:   GradeC,
+		},
+		{
+			name:       "failed handshake",
+			collection: testCollection(imapTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) {
+				*i = probe.TLSInspection{Endpoint: i.Endpoint, Error: "connection reset"}
+			}), nil},
+			reasons: []string{ReasonTLSFailed},
+			score:   75,
+			grade:   GradeC,
+		},
+		{
+			name:        "old TLS versions",
+			collection:  testCollection(imapTLS, smtpTLS),
+			inspections: []*probe.TLSInspection{testInspection(func(i *probe.TLSInspection) { i.SupportedVersions = []string{"TLS 1.0", "TLS 1.1", "TLS 1.2"} })},
+			reasons:     []string{ReasonOldTLS, ReasonOldTLS},
+			score:       90,
+			grade:       GradeA,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := Score_Collection(test.collection, test.inspections)
+
+			reasons := make([]string, 0, len(s.Reasons))
+			for _, r := range s.Reasons {
+				reasons = append(reasons, r.Code)
+				if r.Penalty != Penalties[r.Code] || r.Message == "" {
+					t.Errorf("reason %v", r)
+				}
+			}
+			sort.Strings(reasons)
+			if test.reasons == nil {
+				test.reasons = []string{}
+			}
+			if !reflect.DeepEqual(reasons, test.reasons) {
+				t.Errorf("reasons %q, want %q", reasons, test.reasons)
+			}
+			if s.Score != test.score || s.Grade != test.grade {
+				t.Errorf("score %v %v, want %v %v", s.Score, s.Grade, test.score, test.grade)
+			}
+			if s.Domain != "example.com" {
+				t.Errorf("domain %q", s.Domain)
+			}
+		})
+	}
+}
+
+func TestGradeOf(t *testing.T) {
+	for score, grade := range map[int]Grade{100: GradeA, 90: GradeA, 89: GradeB, 80: GradeB, 79: GradeC, 65: GradeC, 64: GradeD, 50: GradeD, 49: GradeF, 0: GradeF} {
+		if g := gradeOf(score); g != grade {
+			t.Errorf("%v: %v, want %v", score, g, grade)
+		}
+	}
+}
+
+// the certificates of every https url on the way to the settings are inspected
+func TestDiscoveryURLs(t *testing.T) {
+	c := testCollection(imapTLS)
+	c.Autoconfig.FinalURL = "https://config.example.net/config.xml"
+	c.Autoconfig.Redirects = []string{"http://mail.example.com/config.xml", "https://config.example.net/config.xml"}
+	c.Autodiscover = &autodiscover.Discovery{URL: "https://autodiscover.example.com/autodiscover/autodiscover.xml"}
+
+	// `Inspect_Collection` inspects the repeated url once
+	want := []string{
+		"https://config.example.net/config.xml",
+		"https://config.example.net/config.xml",
+		"https://autodiscover.example.com/autodiscover/autodiscover.xml",
+	}
+	if urls := discoveryURLs(c); !reflect.DeepEqual(urls, want) {
+		t.Errorf("urls %q, want %q", urls, want)
+	}
+}
@@ -5,47 +5,106 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
 )
 
+// the config a discovery ended with
+type Discovery struct {
+	URL       string   // the candidate url that returned the config
+	FinalURL  string   // the url the config was read from, differs from URL after redirects
+	Redirects []string // every url redirected to, in order
+	Body      []byte
+	Config    *ClientConfig
+}
+
 func Download_AutoconfigXML(email_address string, suffixlistpath string, path string) error {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return err
+	}
+
 	// download the url_list's XML file to path
-	xmlpath := filepath.Join(path, email_address+".xml")
+	xmlpath := filepath.Join(path, addr.FileName()+".xml")
 
 	dir := filepath.Dir(xmlpath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("error creating directory: %v", dir)
 	}
 
-	parts := strings.Split(email_address, "@")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid email address: %v", email_address)
+	d, err := Discover_Autoconfig(email_address, suffixlistpath)
+	if err != nil {
+		return err
+	}
+	return saveAutoconfigXML(xmlpath, d.Body)
+}
+
+// download the config of every address like `Download_AutoconfigXML`, rejected false positives are counted apart
+func Download_AutoconfigXMLs(email_addresses []string, suffixlistpath string, path string) utils.ResponseStats {
+	var stats utils.ResponseStats
+	for _, email_address := range email_addresses {
+		stats.Record(Download_AutoconfigXML(email_address, suffixlistpath, path))
 	}
+	return stats
+}
 
-	email_domain := parts[1]
-	//email_local := parts[0]
+// find the config for email_address, trying the candidate urls of `Get_AutoconfigURLs` in order
+func Discover_Autoconfig(email_address string, suffixlistpath string) (*Discovery, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	return Discover_AutoconfigFrom(email_address, Get_AutoconfigURLs(addr, suffixlistpath))
+}
+
+// like `Discover_Autoconfig`, but tries the given urls in order, e.g. to emulate a client
+func Discover_AutoconfigFrom(email_address string, url_list []string) (*Discovery, error) {
+	attempts := make([]error, 0, len(url_list))
+	for _, url := range url_list {
+		d, err := fetchAutoconfigXML(url)
+		if err != nil {
+			attempts = append(attempts, err)
+			continue
+		}
+		d.Config, err = Parse_AutoconfigXML(d.Body)
+		if err != nil {
+			attempts = append(attempts, fmt.Errorf("%v: %v", url, err))
+			continue
+		}
+		return d, nil
+	}
+
+	return nil, &utils.NotFoundError{Mechanism: "Autoconfigxml", EmailAddress: email_address, Attempts: attempts}
+}
+
+// the candidate urls for addr in the order of draft-bucksch-autoconfig
+func Get_AutoconfigURLs(addr *utils.EmailAddress, suffixlistpath string) []string {
+	email_domain := addr.ASCIIDomain
+	query := url.Values{"emailaddress": {addr.ASCII()}}
 
 	url_list := make([]string, 0)
 
 	// 1.1. https://autoconfig.%EMAILDOMAIN%/mail/config-v1.1.xml?emailaddress=%EMAILADDRESS% (Required)
-	url_1_1 := "https://autoconfig." + email_domain + "/mail/config-v1.1.xml?emailaddress=" + email_address
+	url_1_1 := utils.Build_URL("https", "autoconfig."+email_domain, "/mail/config-v1.1.xml", query)
 	url_list = append(url_list, url_1_1)
 
 	// 1.2. https://%EMAILDOMAIN%/.well-known/autoconfig/mail/config-v1.1.xml (Recommended)
-	url_1_2 := "https://" + email_domain + "/.well-known/autoconfig/mail/config-v1.1.xml"
+	url_1_2 := utils.Build_URL("https", email_domain, "/.well-known/autoconfig/mail/config-v1.1.xml", nil)
 	url_list = append(url_list, url_1_2)
 
 	// 1.3. http://autoconfig.%EMAILDOMAIN%/mail/config-v1.1.xml(Optional)
-	url_1_3 := "http://autoconfig." + email_domain + "/mail/config-v1.1.xml"
+	url_1_3 := utils.Build_URL("http", "autoconfig."+email_domain, "/mail/config-v1.1.xml", nil)
 	url_list = append(url_list, url_1_3)
 
 	// 2.1. %ISPDB%%EMAILDOMAIN% (Recommended)
 	// %ISPDB% = https://autoconfig.thunderbird.net/v1.1/
-	url_2_1 := "https://autoconfig.thunderbird.net/v1.1/" + email_domain
+	url_2_1 := utils.Build_URL("https", "autoconfig.thunderbird.net", "/v1.1/"+email_domain, nil)
 	url_list = append(url_list, url_2_1)
 
 	// 3
@@ -60,59 +119,99 @@ func Download_AutoconfigXML(email_address string, suffixlistpath string, path st
 		mxmaindomain := mx_full_main_domain[1]
 
 		// 3.1 https://autoconfig.%MXFULLDOMAIN%/mail/config-v1.1.xml?emailaddress=%EMAILADDRESS% (Recommended)
-		url_3_1 := "https://autoconfig." + mxfulldomain + "/mail/config-v1.1.xml?emailaddress=" + email_address
+		url_3_1 := utils.Build_URL("https", "autoconfig."+mxfulldomain, "/mail/config-v1.1.xml", query)
 		url_list = append(url_list, url_3_1)
 
 		// 3.2 https://autoconfig.%MXMAINDOMAIN%/mail/config-v1.1.xml?emailaddress=%EMAILADDRESS% (Recommended)
-		url_3_2 := "https://autoconfig." + mxmaindomain + "/mail/config-v1.1.xml?emailaddress=" + email_address
+		url_3_2 := utils.Build_URL("https", "autoconfig."+mxmaindomain, "/mail/config-v1.1.xml", query)
 		url_list = append(url_list, url_3_2)
 
 		// 3.3 %ISPDB%%MXFULLDOMAIN% (Recommended)
-		url_3_3 := "https://autoconfig.thunderbird.net/v1.1/" + mxfulldomain
+		url_3_3 := utils.Build_URL("https", "autoconfig.thunderbird.net", "/v1.1/"+mxfulldomain, nil)
 		url_list = append(url_list, url_3_3)
 
 		// 3.4 %ISPDB%%MXMAINDOMAIN% (Recommended)
-		url_3_4 := "https://autoconfig.thunderbird.net/v1.1/" + mxmaindomain
+		url_3_4 := utils.Build_URL("https", "autoconfig.thunderbird.net", "/v1.1/"+mxmaindomain, nil)
 		url_list = append(url_list, url_3_4)
 	}
 
-	for _, url := range url_list {
-		err := Get_AutoconfigXML(url, xmlpath)
-		if err == nil {
-			return nil
-		}
-	}
-
-	return fmt.Errorf("can't find Autoconfigxml file for %v", email_address)
-
+	return url_list
 }
 
 // download the autoconfig.xml (use GET) file to xmlpath
+// HTTP 200 responses that are not an autoconfig document return a `*utils.RejectedResponseError`
 func Get_AutoconfigXML(url string, xmlpath string) error {
-	resp, err := http.Get(url)
+	body, err := Fetch_AutoconfigXML(url)
 	if err != nil {
 		return err
 	}
+	return saveAutoconfigXML(xmlpath, body)
+}
+
+// GET url and return the autoconfig document, errors like `Get_AutoconfigXML`
+func Fetch_AutoconfigXML(url string) ([]byte, error) {
+	d, err := fetchAutoconfigXML(url)
+	if err != nil {
+		return nil, err
+	}
+	return d.Body, nil
+}
+
+// like `Fetch_AutoconfigXML`, but records where the redirects went. Config is left nil.
+func fetchAutoconfigXML(url string) (*Discovery, error) {
+	d := &Discovery{URL: url, FinalURL: url}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			d.Redirects = append(d.Redirects, req.URL.String())
+			return nil
+		},
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
+	d.FinalURL = resp.Request.URL.String()
 
 	if resp.StatusCode == http.StatusOK {
-		outFile, err := os.Create(xmlpath)
+		d.Body, err = io.ReadAll(resp.Body)
 		if err != nil {
-			return fmt.Errorf("error creating file: %v", err)
+			return nil, err
 		}
 
-		defer outFile.Close()
-
-		_, err = io.Copy(outFile, resp.Body)
-		if err != nil {
-			return fmt.Errorf("error saving to file: %v", xmlpath)
+		class := classifyAutoconfigResponse(client, d.FinalURL, resp, d.Body)
+		if class.Class != utils.ResponseValid {
+			return nil, &utils.RejectedResponseError{URL: url, Classification: class}
 		}
 
-		return nil
+		return d, nil
 	}
 
-	return fmt.Errorf("error downloading file: %v", url)
+	return nil, fmt.Errorf("error downloading file: %v", url)
+}
 
+func saveAutoconfigXML(xmlpath string, body []byte) error {
+	err := os.WriteFile(xmlpath, body, 0644)
+	if err != nil {
+		return fmt.Errorf("error saving to file: %v", xmlpath)
+	}
+	return nil
+}
+
+// check a HTTP 200 response is an autoconfig document and not a catch-all page of the host
+func classifyAutoconfigResponse(client *http.Client, url string, resp *http.Response, body []byte) utils.Classification {
+	class := utils.Classify_Response(resp.Header.Get("Content-Type"), body, "clientConfig")
+	if utils.Needs_SoftNotFoundProbe(class) {
+		probe, err := utils.Probe_RandomPath(client, http.MethodGet, url, "", nil)
+		if err == nil {
+			class = utils.Check_SoftNotFound(class, body, probe)
+		}
+	}
+	return class
 }
 
 // Save the public suffix list to a file in fomat of json
@@ -156,26 +255,43 @@ func Get_PublicSuffixList(suffixlistpath string) error {
 
 // `Get_MX_record_SLD` call `ExtractSLD_localpuffixlist` and `loadMapFromFile`
 func Get_MX_full_main_domain(domain string, suffixlistpath string) ([2]string, error) {
+	mxdomains, _, err := Get_MX_full_main_domainDNSSEC(domain, suffixlistpath)
+	return mxdomains, err
+}
+
+// like `Get_MX_full_main_domain`, and how the MX lookup validated
+func Get_MX_full_main_domainDNSSEC(domain string, suffixlistpath string) ([2]string, utils.DNSSECResult, error) {
+	var dnssec utils.DNSSECResult
 	tldMap, err := loadMapFromFile(suffixlistpath)
 	if err != nil {
-		return [2]string{"", ""}, err
+		return [2]string{"", ""}, dnssec, err
 	}
 
-	mx, err := net.LookupMX(domain)
+	domain, err = utils.To_ASCIIHost(domain)
 	if err != nil {
-		return [2]string{"", ""}, err
+		return [2]string{"", ""}, dnssec, err
 	}
 
-	mxmaindomian, err := Extract_SLDFromTLDmap(mx[0].Host, tldMap)
+	mx, dnssec, err := utils.Lookup_MX(domain)
 	if err != nil {
-		return [2]string{"", ""}, err
+		return [2]string{"", ""}, dnssec, err
+	}
+
+	mxhost, err := utils.To_ASCIIHost(mx[0].Host)
+	if err != nil {
+		return [2]string{"", ""}, dnssec, err
+	}
+
+	mxmaindomian, err := Extract_SLDFromTLDmap(mxhost, tldMap)
+	if err != nil {
+		return [2]string{"", ""}, dnssec, err
 
 	}
 
-	tmp1 := strings.Split(mx[0].Host, ".")
+	tmp1 := strings.Split(mxhost, ".")
 	mxfulldomain := strings.Join(tmp1[1:], ".")
 
-	return [2]string{mxfulldomain, mxmaindomian}, nil
+	return [2]string{mxfulldomain, mxmaindomian}, dnssec, nil
 }
 
 // Extract the second-level domain from a domain name using tldmap
@@ -200,6 +316,11 @@ func Extract_SLDFromTLDmap(domain string, tldMap map[string]bool) (string, error
 	return "", fmt.Errorf("no public suffix found for domain: %s", domain)
 }
 
+// load the public suffix list saved by `Get_PublicSuffixList`
+func Load_PublicSuffixList(suffixlistpath string) (map[string]bool, error) {
+	return loadMapFromFile(suffixlistpath)
+}
+
 // load a file in format of json to a map[string]bool
 func loadMapFromFile(suffixlistpath string) (map[string]bool, error) {
 	file, err := os.Open(suffixlistpath)
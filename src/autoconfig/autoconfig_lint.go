@@ -0,0 +1,384 @@
+package autoconfig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// a single problem found in an autoconfig document
+type Finding struct {
+	Code     string
+	Severity Severity
+	Path     string // element the finding refers to, e.g. "clientConfig/emailProvider/incomingServer[1]/port"
+	Message  string
+}
+
+func (f Finding) String() string {
+	if f.Path == "" {
+		return fmt.Sprintf("%s %s: %s", f.Severity, f.Code, f.Message)
+	}
+	return fmt.Sprintf("%s %s: %s (%s)", f.Severity, f.Code, f.Message, f.Path)
+}
+
+// finding codes, see `Lint_AutoconfigXML`
+const (
+	LintEmptyDocument      = "AC001"
+	LintHTMLDocument       = "AC002"
+	LintMalformedXML       = "AC003"
+	LintWrongRoot          = "AC004"
+	LintVersion            = "AC005"
+	LintMissingProvider    = "AC010"
+	LintMissingProviderID  = "AC011"
+	LintMissingDomain      = "AC012"
+	LintDomainMismatch     = "AC013"
+	LintMissingDisplayName = "AC014"
+	LintNoIncomingServer   = "AC020"
+	LintNoOutgoingServer   = "AC021"
+	LintServerType         = "AC030"
+	LintMissingHostname    = "AC031"
+	LintPort               = "AC032"
+	LintMissingSocketType  = "AC033"
+	LintSocketType         = "AC034"
+	LintMissingUsername    = "AC035"
+	LintMissingAuth        = "AC036"
+	LintAuthentication     = "AC037"
+	LintCleartextPassword  = "AC038"
+	LintUnknownPlaceholder = "AC039"
+	LintMultipleProviders  = "AC040"
+	LintURL                = "AC041"
+	LintServiceType        = "AC042"
+	LintOAuth2             = "AC043"
+	LintLegacyExchange     = "AC044"
+)
+
+var validSocketTypes = map[string]bool{
+	"plain":    true,
+	"SSL":      true,
+	"STARTTLS": true,
+}
+
+var validIncomingTypes = map[string]bool{
+	"imap":       true,
+	"pop3":       true,
+	"jmap":       true,
+	"ews":        true,
+	"activesync": true,
+	"exchange":   true, // Thunderbird's legacy name for ews/activesync
+}
+
+var validOutgoingTypes = map[string]bool{
+	"smtp": true,
+}
+
+var placeholderPattern = regexp.MustCompile(`%[A-Za-z_]+%`)
+
+// stands in for the address to check urls with placeholders
+var lintAddress = &utils.EmailAddress{LocalPart: "user", Domain: "example.com", ASCIIDomain: "example.com"}
+
+var knownPlaceholders = map[string]bool{
+	"%EMAILADDRESS%":   true,
+	"%EMAILLOCALPART%": true,
+	"%EMAILDOMAIN%":    true,
+}
+
+// validate an autoconfig document against the draft-bucksch / mailmaint schema.
+// email_domain is the domain the document was fetched for, it may be empty.
+func Lint_AutoconfigXML(data []byte, email_domain string) []Finding {
+	findings := make([]Finding, 0)
+	add := func(code string, severity Severity, path string, format string, args ...any) {
+		findings = append(findings, Finding{Code: code, Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		add(LintEmptyDocument, SeverityError, "", "document is empty")
+		return findings
+	}
+
+	if utils.Is_HTML(trimmed) {
+		add(LintHTMLDocument, SeverityError, "", "document is an HTML page, not an autoconfig file")
+		return findings
+	}
+
+	root, providers, err := scanXML(data)
+	if err != nil {
+		add(LintMalformedXML, SeverityError, "", "document is not well-formed XML: %v", err)
+		return findings
+	}
+	if root != "clientConfig" {
+		add(LintWrongRoot, SeverityError, root, "root element is <%s>, expected <clientConfig>", root)
+		return findings
+	}
+	if providers > 1 {
+		add(LintMultipleProviders, SeverityWarning, "clientConfig/emailProvider", "%d emailProvider elements, clients only use the first", providers)
+	}
+
+	config, err := Parse_AutoconfigXML(data)
+	if err != nil {
+		add(LintMalformedXML, SeverityError, "", "%v", err)
+		return findings
+	}
+
+	if config.Version == "" {
+		add(LintVersion, SeverityWarning, "clientConfig", "missing version attribute")
+	} else if config.Version != "1.1" {
+		add(LintVersion, SeverityInfo, "clientConfig", "unexpected version %q", config.Version)
+	}
+
+	if providers == 0 {
+		add(LintMissingProvider, SeverityError, "clientConfig", "missing <emailProvider>")
+		return findings
+	}
+
+	provider := config.Provider()
+	path := "clientConfig/emailProvider"
+	if strings.TrimSpace(provider.ID) == "" {
+		add(LintMissingProviderID, SeverityError, path, "emailProvider has no id attribute")
+	}
+
+	if len(provider.Domains) == 0 {
+		add(LintMissingDomain, SeverityError, path, "no <domain> listed")
+	} else if email_domain != "" {
+		found := false
+		ascii_domain, _ := utils.To_ASCIIHost(email_domain)
+		for _, d := range provider.Domains {
+			d = strings.TrimSpace(d)
+			if strings.EqualFold(d, email_domain) || strings.EqualFold(d, ascii_domain) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add(LintDomainMismatch, SeverityWarning, path+"/domain", "queried domain %s is not listed in <domain>", email_domain)
+		}
+	}
+
+	if strings.TrimSpace(provider.DisplayName) == "" {
+		add(LintMissingDisplayName, SeverityInfo, path, "missing <displayName>")
+	}
+
+	if len(provider.IncomingServers) == 0 {
+		add(LintNoIncomingServer, SeverityError, path, "no <incomingServer>")
+	}
+	if len(provider.OutgoingServers) == 0 {
+		add(LintNoOutgoingServer, SeverityError, path, "no <outgoingServer>")
+	}
+
+	for i, s := range provider.IncomingServers {
+		findings = append(findings, lintServer(s, fmt.Sprintf("%s/incomingServer[%d]", path, i+1), validIncomingTypes, false)...)
+	}
+	for i, s := range provider.OutgoingServers {
+		findings = append(findings, lintServer(s, fmt.Sprintf("%s/outgoingServer[%d]", path, i+1), validOutgoingTypes, true)...)
+	}
+
+	for i, s := range provider.AddressBooks {
+		findings = append(findings, lintService(s, fmt.Sprintf("%s/addressBook[%d]", path, i+1), "carddav")...)
+	}
+	for i, s := range provider.Calendars {
+		findings = append(findings, lintService(s, fmt.Sprintf("%s/calendar[%d]", path, i+1), "caldav")...)
+	}
+	for i, s := range provider.FileShares {
+		findings = append(findings, lintService(s, fmt.Sprintf("%s/fileShare[%d]", path, i+1), "webdav")...)
+	}
+
+	if Has_ServerType(config, "oauth2") {
+		if config.OAuth2 == nil {
+			add(LintOAuth2, SeverityWarning, "clientConfig", "authentication OAuth2 is offered but <oAuth2> is missing")
+		} else if config.OAuth2.Issuer == "" || config.OAuth2.Scope == "" || config.OAuth2.AuthURL == "" || config.OAuth2.TokenURL == "" {
+			add(LintOAuth2, SeverityWarning, "clientConfig/oAuth2", "<oAuth2> needs issuer, scope, authURL and tokenURL")
+		}
+	}
+
+	return findings
+}
+
+func lintServer(s Server, path string, validTypes map[string]bool, outgoing bool) []Finding {
+	findings := make([]Finding, 0)
+	add := func(code string, severity Severity, path string, format string, args ...any) {
+		findings = append(findings, Finding{Code: code, Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type == "" {
+		add(LintServerType, SeverityError, path, "missing type attribute")
+	} else if !validTypes[s.Type] {
+		add(LintServerType, SeverityError, path, "invalid server type %q", s.Type)
+	}
+
+	if s.Type == "exchange" {
+		add(LintLegacyExchange, SeverityInfo, path, "legacy server type \"exchange\", the mailmaint draft uses \"ews\" or \"activesync\"")
+	}
+
+	if urlServerTypes[s.Type] {
+		rawurl := serverURL(s)
+		if strings.TrimSpace(rawurl) == "" && s.Type == "exchange" {
+			add(LintURL, SeverityError, path, "missing <ewsURL>, <easURL> or <owaURL> for server type %q", s.Type)
+		} else if strings.TrimSpace(rawurl) == "" {
+			add(LintURL, SeverityError, path, "missing <url> for server type %q", s.Type)
+		} else if u, err := url.Parse(strings.TrimSpace(expandPlaceholders(rawurl, lintAddress))); err != nil || u.Host == "" {
+			add(LintURL, SeverityError, path+"/url", "invalid url %q", rawurl)
+		} else if u.Scheme != "https" {
+			add(LintURL, SeverityWarning, path+"/url", "url %q does not use https", rawurl)
+		}
+		findings = append(findings, lintAuthentication(s.Authentication, path, "", outgoing)...)
+		return findings
+	}
+
+	if strings.TrimSpace(s.Hostname) == "" {
+		add(LintMissingHostname, SeverityError, path, "missing <hostname>")
+	}
+
+	if strings.TrimSpace(s.Port) == "" {
+		add(LintPort, SeverityError, path, "missing <port>")
+	} else if port, err := strconv.Atoi(strings.TrimSpace(s.Port)); err != nil || port <= 0 || port > 65535 {
+		add(LintPort, SeverityError, path+"/port", "invalid port %q", s.Port)
+	}
+
+	socketType := strings.TrimSpace(s.SocketType)
+	if socketType == "" {
+		add(LintMissingSocketType, SeverityError, path, "missing <socketType>")
+	} else if !validSocketTypes[socketType] {
+		add(LintSocketType, SeverityError, path+"/socketType", "invalid socketType %q", s.SocketType)
+	}
+
+	if strings.TrimSpace(s.Username) == "" {
+		add(LintMissingUsername, SeverityWarning, path, "missing <username>")
+	}
+
+	findings = append(findings, lintAuthentication(s.Authentication, path, socketType, outgoing)...)
+
+	for _, field := range []string{s.Hostname, s.Username} {
+		for _, p := range placeholderPattern.FindAllString(field, -1) {
+			if !knownPlaceholders[p] {
+				add(LintUnknownPlaceholder, SeverityInfo, path, "unknown placeholder %s", p)
+			}
+		}
+	}
+
+	return findings
+}
+
+func lintAuthentication(methods []string, path string, socketType string, outgoing bool) []Finding {
+	findings := make([]Finding, 0)
+	add := func(code string, severity Severity, path string, format string, args ...any) {
+		findings = append(findings, Finding{Code: code, Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(methods) == 0 {
+		add(LintMissingAuth, SeverityError, path, "missing <authentication>")
+	}
+	for _, auth := range methods {
+		auth = strings.TrimSpace(auth)
+		if !knownAuthentication[auth] || (auth == "smtp-after-pop" && !outgoing) {
+			add(LintAuthentication, SeverityError, path+"/authentication", "invalid authentication %q", auth)
+		}
+		if auth == "password-cleartext" && socketType == "plain" {
+			add(LintCleartextPassword, SeverityWarning, path, "password-cleartext over a plain socket sends the password unencrypted")
+		}
+	}
+
+	return findings
+}
+
+// addressBook, calendar and fileShare elements of the mailmaint draft
+func lintService(s Service, path string, serviceType string) []Finding {
+	findings := make([]Finding, 0)
+	add := func(code string, severity Severity, path string, format string, args ...any) {
+		findings = append(findings, Finding{Code: code, Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.Type != serviceType {
+		add(LintServiceType, SeverityError, path, "invalid type %q, expected %q", s.Type, serviceType)
+	}
+
+	if strings.TrimSpace(s.URL) == "" {
+		add(LintURL, SeverityError, path, "missing <url>")
+	} else if u, err := url.Parse(strings.TrimSpace(expandPlaceholders(s.URL, lintAddress))); err != nil || u.Host == "" {
+		add(LintURL, SeverityError, path+"/url", "invalid url %q", s.URL)
+	} else if u.Scheme != "https" {
+		add(LintURL, SeverityWarning, path+"/url", "url %q does not use https", s.URL)
+	}
+
+	if len(s.Authentication) > 0 {
+		findings = append(findings, lintAuthentication(s.Authentication, path, "", false)...)
+	}
+
+	return findings
+}
+
+// lint an autoconfig file saved by `Download_AutoconfigXML`, the queried domain is taken from the file name
+func Lint_AutoconfigFile(xmlpath string) ([]Finding, error) {
+	data, err := os.ReadFile(xmlpath)
+	if err != nil {
+		return nil, err
+	}
+
+	email_domain := ""
+	name := strings.TrimSuffix(filepath.Base(xmlpath), ".xml")
+	if addr, err := utils.Parse_EmailAddress(name); err == nil {
+		email_domain = addr.Domain
+	}
+
+	return Lint_AutoconfigXML(data, email_domain), nil
+}
+
+// check whether any finding has error severity
+func Has_LintErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// walk the whole document, return the root element name and the number of emailProvider elements
+func scanXML(data []byte) (string, int, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	root := ""
+	providers := 0
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return root, providers, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				root = t.Name.Local
+			} else if depth == 1 && t.Name.Local == "emailProvider" {
+				providers++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	if root == "" {
+		return root, providers, fmt.Errorf("no root element")
+	}
+	return root, providers, nil
+}
@@ -0,0 +1,113 @@
+package autoconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+// a config with everything a client needs, servers and extra elements are spliced in by the tests
+func lintConfig(provider string, extra string) string {
+	return `<?xml version="1.0"?><clientConfig version="1.1">` + provider + extra + `</clientConfig>`
+}
+
+const lintIMAP = `<incomingServer type="imap">
+	<hostname>imap.example.com</hostname><port>993</port><socketType>SSL</socketType>
+	<username>%EMAILADDRESS%</username><authentication>password-cleartext</authentication>
+</incomingServer>`
+
+const lintSMTP = `<outgoingServer type="smtp">
+	<hostname>smtp.example.com</hostname><port>465</port><socketType>SSL</socketType>
+	<username>%EMAILADDRESS%</username><authentication>password-cleartext</authentication>
+</outgoingServer>`
+
+func lintProvider(servers string) string {
+	return `<emailProvider id="example.com"><domain>example.com</domain><displayName>Example</displayName>` + servers + `</emailProvider>`
+}
+
+func codes(findings []Finding) map[string]bool {
+	found := make(map[string]bool)
+	for _, f := range findings {
+		found[f.Code] = true
+	}
+	return found
+}
+
+func TestLintAutoconfigXML(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		domain string
+		want   []string // codes that must be found
+	}{
+		{"empty", "  \n", "", []string{LintEmptyDocument}},
+		{"html", "<!DOCTYPE html><html><body>404</body></html>", "", []string{LintHTMLDocument}},
+		{"malformed", "<clientConfig><emailProvider>", "", []string{LintMalformedXML}},
+		{"wrong root", "<config/>", "", []string{LintWrongRoot}},
+		{"version", `<clientConfig>` + lintProvider(lintIMAP+lintSMTP) + `</clientConfig>`, "", []string{LintVersion}},
+		{"no provider", lintConfig("", ""), "", []string{LintMissingProvider}},
+		{"provider without id", lintConfig(`<emailProvider><domain>example.com</domain><displayName>x</displayName>`+lintIMAP+lintSMTP+`</emailProvider>`, ""), "", []string{LintMissingProviderID}},
+		{"no domain", lintConfig(`<emailProvider id="x"><displayName>x</displayName>`+lintIMAP+lintSMTP+`</emailProvider>`, ""), "", []string{LintMissingDomain}},
+		{"domain mismatch", lintConfig(lintProvider(lintIMAP+lintSMTP), ""), "example.org", []string{LintDomainMismatch}},
+		{"no display name", lintConfig(`<emailProvider id="x"><domain>example.com</domain>`+lintIMAP+lintSMTP+`</emailProvider>`, ""), "", []string{LintMissingDisplayName}},
+		{"no servers", lintConfig(lintProvider(""), ""), "", []string{LintNoIncomingServer, LintNoOutgoingServer}},
+		{"server type", lintConfig(lintProvider(strings.Replace(lintIMAP, `"imap"`, `"nntp"`, 1)+lintSMTP), ""), "", []string{LintServerType}},
+		{"server fields", lintConfig(lintProvider(`<incomingServer type="imap"><port>99999</port><socketType>TLS1.3</socketType></incomingServer>`+lintSMTP), ""), "",
+			[]string{LintMissingHostname, LintPort, LintSocketType, LintMissingUsername, LintMissingAuth}},
+		{"missing socketType", lintConfig(lintProvider(`<incomingServer type="imap"><hostname>imap.example.com</hostname><port>143</port><username>u</username><authentication>OAuth2</authentication></incomingServer>`+lintSMTP), ""), "",
+			[]string{LintMissingSocketType}},
+		{"authentication", lintConfig(lintProvider(strings.Replace(lintIMAP, "password-cleartext", "smtp-after-pop", 1)+lintSMTP), ""), "", []string{LintAuthentication}},
+		{"cleartext password", lintConfig(lintProvider(strings.Replace(lintIMAP, "SSL", "plain", 1)+lintSMTP), ""), "", []string{LintCleartextPassword}},
+		{"unknown placeholder", lintConfig(lintProvider(strings.Replace(lintIMAP, "%EMAILADDRESS%", "%USERNAME%", 1)+lintSMTP), ""), "", []string{LintUnknownPlaceholder}},
+		{"multiple providers", lintConfig(lintProvider(lintIMAP+lintSMTP)+lintProvider(lintIMAP+lintSMTP), ""), "", []string{LintMultipleProviders}},
+		{"url", lintConfig(lintProvider(lintIMAP+`<incomingServer type="jmap"><url>http://jmap.example.com/</url><authentication>OAuth2</authentication></incomingServer>`+
+			`<incomingServer type="ews"><authentication>NTLM</authentication></incomingServer>`+lintSMTP), `<oAuth2><issuer>example.com</issuer><scope>mail</scope><authURL>https://example.com/auth</authURL><tokenURL>https://example.com/token</tokenURL></oAuth2>`), "",
+			[]string{LintURL}},
+		{"service type", lintConfig(lintProvider(lintIMAP+lintSMTP+`<addressBook type="caldav"><url>https://dav.example.com/</url></addressBook>`), ""), "", []string{LintServiceType}},
+		{"oauth2", lintConfig(lintProvider(strings.Replace(lintIMAP, "password-cleartext", "OAuth2", 1)+lintSMTP), ""), "", []string{LintOAuth2}},
+		{"legacy exchange", lintConfig(lintProvider(lintIMAP+`<incomingServer type="exchange"><ewsURL>https://outlook.example.com/ews/exchange.asmx</ewsURL><authentication>NTLM</authentication></incomingServer>`+lintSMTP), ""), "",
+			[]string{LintLegacyExchange}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found := codes(Lint_AutoconfigXML([]byte(test.data), test.domain))
+			for _, code := range test.want {
+				if !found[code] {
+					t.Errorf("%v not found in %v", code, found)
+				}
+			}
+		})
+	}
+}
+
+func TestLintValidConfig(t *testing.T) {
+	data := lintConfig(lintProvider(lintIMAP+lintSMTP), "")
+	if findings := Lint_AutoconfigXML([]byte(data), "example.com"); len(findings) != 0 {
+		t.Errorf("findings for a valid config: %v", findings)
+	}
+}
+
+// clients only use the first emailProvider, so the servers of the others must not be resolved
+func TestMultipleProvidersResolveFirst(t *testing.T) {
+	second := `<emailProvider id="other.example"><domain>other.example</domain>
+		<incomingServer type="pop3"><hostname>pop.other.example</hostname><port>995</port><socketType>SSL</socketType><username>u</username><authentication>password-cleartext</authentication></incomingServer>
+	</emailProvider>`
+	config, err := Parse_AutoconfigXML([]byte(lintConfig(lintProvider(lintIMAP+lintSMTP)+second, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.EmailProviders) != 2 {
+		t.Fatalf("%v providers", len(config.EmailProviders))
+	}
+
+	settings, err := Resolve_EffectiveSettings(config, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ProviderID != "example.com" || settings.Incoming.Hostname != "imap.example.com" || len(settings.IncomingAlt) != 0 {
+		t.Errorf("resolved %+v, alternatives %+v", settings.Incoming, settings.IncomingAlt)
+	}
+	if types := Get_ServerTypes(config); len(types) != 2 || types[0] != "imap" || types[1] != "smtp" {
+		t.Errorf("server types %v", types)
+	}
+}
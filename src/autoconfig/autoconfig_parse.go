@@ -0,0 +1,88 @@
+package autoconfig
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// ClientConfig struct, the root element of a config-v1.1.xml file
+type ClientConfig struct {
+	XMLName        xml.Name        `xml:"clientConfig"`
+	Version        string          `xml:"version,attr"`
+	EmailProviders []EmailProvider `xml:"emailProvider"`    // clients only use the first
+	OAuth2         *OAuth2         `xml:"oAuth2,omitempty"` // mailmaint draft, details for authentication "OAuth2"
+}
+
+// the emailProvider clients use, the first one. Empty if the config has none.
+func (c *ClientConfig) Provider() *EmailProvider {
+	if len(c.EmailProviders) == 0 {
+		return &EmailProvider{}
+	}
+	return &c.EmailProviders[0]
+}
+
+type EmailProvider struct {
+	ID               string          `xml:"id,attr"`
+	Domains          []string        `xml:"domain"`
+	DisplayName      string          `xml:"displayName"`
+	DisplayShortName string          `xml:"displayShortName"`
+	IncomingServers  []Server        `xml:"incomingServer"` // in order of preference
+	OutgoingServers  []Server        `xml:"outgoingServer"` // in order of preference
+	AddressBooks     []Service       `xml:"addressBook"`    // mailmaint draft, type "carddav"
+	Calendars        []Service       `xml:"calendar"`       // mailmaint draft, type "caldav"
+	FileShares       []Service       `xml:"fileShare"`      // mailmaint draft, type "webdav"
+	Documentation    []Documentation `xml:"documentation"`
+}
+
+type Server struct {
+	Type           string   `xml:"type,attr"` // incoming: {"imap", "pop3", "jmap", "ews", "activesync"}, outgoing: {"smtp"}
+	Hostname       string   `xml:"hostname"`
+	URL            string   `xml:"url,omitempty"` // used instead of hostname/port by "jmap", "ews" and "activesync"
+	Port           string   `xml:"port"`          // kept as text, some files put garbage here
+	SocketType     string   `xml:"socketType"`    // it's value belongs to {"plain", "SSL", "STARTTLS"}
+	Username       string   `xml:"username"`
+	Password       string   `xml:"password,omitempty"`
+	Authentication []string `xml:"authentication"`   // in order of preference
+	OwaURL         string   `xml:"owaURL,omitempty"` // Thunderbird's legacy type "exchange"
+	EwsURL         string   `xml:"ewsURL,omitempty"`
+	EasURL         string   `xml:"easURL,omitempty"`
+}
+
+// addressBook, calendar and fileShare elements
+type Service struct {
+	Type           string   `xml:"type,attr"` // it's value belongs to {"carddav", "caldav", "webdav"}
+	URL            string   `xml:"url"`
+	Username       string   `xml:"username"`
+	Authentication []string `xml:"authentication"`
+}
+
+type OAuth2 struct {
+	Issuer   string `xml:"issuer"`
+	Scope    string `xml:"scope"`
+	AuthURL  string `xml:"authURL"`
+	TokenURL string `xml:"tokenURL"`
+}
+
+type Documentation struct {
+	URL   string   `xml:"url,attr"`
+	Descr []string `xml:"descr"`
+}
+
+// parse the content of an autoconfig file
+func Parse_AutoconfigXML(data []byte) (*ClientConfig, error) {
+	var config ClientConfig
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing autoconfig: %v", err)
+	}
+	return &config, nil
+}
+
+// parse an autoconfig file saved by `Download_AutoconfigXML`
+func Load_AutoconfigXML(xmlpath string) (*ClientConfig, error) {
+	data, err := os.ReadFile(xmlpath)
+	if err != nil {
+		return nil, err
+	}
+	return Parse_AutoconfigXML(data)
+}
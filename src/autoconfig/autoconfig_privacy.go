@@ -0,0 +1,207 @@
+package autoconfig
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// whether a client querying the domain's own autoconfig hosts leaks the email address in cleartext
+type PrivacyResult struct {
+	EmailAddress          string
+	Domain                string
+	HTTPSConfig           bool   // a usable config at candidate 1.1 or 1.2, after their redirects
+	HTTPConfig            bool   // a usable config over cleartext HTTP, at candidate 1.3 or where 1.1 or 1.2 redirect to
+	HTTPOnly              bool   // a usable config is only reachable via cleartext HTTP
+	HTTPReachable         bool   // http://autoconfig.%EMAILDOMAIN% answered at all
+	HTTPRedirectsToHTTPS  bool   // the cleartext candidate redirects to https
+	RedirectLocation      string // Location of that redirect
+	HSTS                  bool   // https://autoconfig.%EMAILDOMAIN% sends Strict-Transport-Security
+	HSTSMaxAge            int
+	HSTSIncludeSubDomains bool
+	AddressExposed        bool // a client falls back to candidate 1.3 and sends ?emailaddress= in cleartext
+}
+
+type PrivacyReport struct {
+	Domains              int
+	HTTPSConfig          int
+	HTTPConfig           int
+	HTTPOnly             int
+	HTTPRedirectsToHTTPS int
+	HSTS                 int
+	AddressExposed       int
+}
+
+type PrivacyAnalyzer struct {
+	Timeout   time.Duration
+	Transport http.RoundTripper // nil for `http.DefaultTransport`
+}
+
+func New_PrivacyAnalyzer() *PrivacyAnalyzer {
+	return &PrivacyAnalyzer{Timeout: 30 * time.Second}
+}
+
+func Analyze_Privacy(email_address string) (*PrivacyResult, error) {
+	return New_PrivacyAnalyzer().Analyze(email_address)
+}
+
+// check the domain's own autoconfig candidates (1.1 - 1.3) the way a client would query them
+func (a *PrivacyAnalyzer) Analyze(email_address string) (*PrivacyResult, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PrivacyResult{EmailAddress: addr.String(), Domain: addr.ASCIIDomain}
+	query := url.Values{"emailaddress": {addr.ASCII()}}
+
+	// clients follow the redirects of the https candidates like any other
+	client := &http.Client{Timeout: a.Timeout, Transport: a.Transport}
+	// the redirect of the cleartext candidate is inspected, not followed
+	noFollow := &http.Client{
+		Timeout:   a.Timeout,
+		Transport: a.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// 1.1, the HSTS header is taken from this host whatever the status code is
+	url_1_1 := utils.Build_URL("https", "autoconfig."+addr.ASCIIDomain, "/mail/config-v1.1.xml", query)
+	resp, valid, err := checkAutoconfigURL(client, url_1_1)
+	if err == nil {
+		result.HTTPSConfig = valid && finalScheme(resp) == "https"
+		result.HTTPConfig = valid && finalScheme(resp) != "https"
+		result.HSTS, result.HSTSMaxAge, result.HSTSIncludeSubDomains = parseHSTS(firstResponse(resp).Header.Get("Strict-Transport-Security"))
+	}
+
+	// 1.2
+	if !result.HTTPSConfig {
+		url_1_2 := utils.Build_URL("https", addr.ASCIIDomain, "/.well-known/autoconfig/mail/config-v1.1.xml", nil)
+		resp, valid, err := checkAutoconfigURL(client, url_1_2)
+		if err == nil {
+			result.HTTPSConfig = valid && finalScheme(resp) == "https"
+			result.HTTPConfig = result.HTTPConfig || valid && finalScheme(resp) != "https"
+		}
+	}
+
+	// 1.3, real clients send the address in the query string here too
+	url_1_3 := utils.Build_URL("http", "autoconfig."+addr.ASCIIDomain, "/mail/config-v1.1.xml", query)
+	resp, valid, err = checkAutoconfigURL(noFollow, url_1_3)
+	if err == nil {
+		result.HTTPReachable = true
+		result.HTTPConfig = result.HTTPConfig || valid
+
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+			location, err := resp.Location()
+			if err == nil {
+				result.RedirectLocation = location.String()
+				result.HTTPRedirectsToHTTPS = location.Scheme == "https"
+			}
+		}
+	}
+
+	result.HTTPOnly = result.HTTPConfig && !result.HTTPSConfig
+	// a client only gets to 1.3 if 1.1 and 1.2 failed, the request is sent before any redirect is seen
+	result.AddressExposed = !result.HTTPSConfig && (result.HTTPReachable || result.HTTPConfig)
+
+	return result, nil
+}
+
+// aggregate the results of `Analyze_Privacy`
+func Summarize_Privacy(results []*PrivacyResult) PrivacyReport {
+	report := PrivacyReport{}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		report.Domains++
+		if r.HTTPSConfig {
+			report.HTTPSConfig++
+		}
+		if r.HTTPConfig {
+			report.HTTPConfig++
+		}
+		if r.HTTPOnly {
+			report.HTTPOnly++
+		}
+		if r.HTTPRedirectsToHTTPS {
+			report.HTTPRedirectsToHTTPS++
+		}
+		if r.HSTS {
+			report.HSTS++
+		}
+		if r.AddressExposed {
+			report.AddressExposed++
+		}
+	}
+	return report
+}
+
+// GET url and check whether the answer is a usable autoconfig document
+func checkAutoconfigURL(client *http.Client, url string) (*http.Response, bool, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp, false, nil
+	}
+
+	// the random path is probed on the host that answered
+	class := classifyAutoconfigResponse(client, resp.Request.URL.String(), resp, body)
+	return resp, class.Class == utils.ResponseValid, nil
+}
+
+// the scheme of the url a followed request ended at
+func finalScheme(resp *http.Response) string {
+	return resp.Request.URL.Scheme
+}
+
+// the response of the first request of a redirect chain
+func firstResponse(resp *http.Response) *http.Response {
+	for resp.Request != nil && resp.Request.Response != nil {
+		resp = resp.Request.Response
+	}
+	return resp
+}
+
+// parse a Strict-Transport-Security header, RFC 6797 6.1
+func parseHSTS(header string) (bool, int, bool) {
+	if header == "" {
+		return false, 0, false
+	}
+
+	maxAge := -1
+	includeSubDomains := false
+	for _, directive := range strings.Split(header, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			age, err := strconv.Atoi(strings.Trim(strings.TrimSpace(value), `"`))
+			if err == nil {
+				maxAge = age
+			}
+		case "includesubdomains":
+			includeSubDomains = true
+		}
+	}
+
+	// max-age is required, max-age=0 removes the policy
+	if maxAge <= 0 {
+		return false, 0, includeSubDomains
+	}
+	return true, maxAge, includeSubDomains
+}
@@ -0,0 +1,178 @@
+package autoconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHSTS(t *testing.T) {
+	tests := []struct {
+		header            string
+		hsts              bool
+		maxAge            int
+		includeSubDomains bool
+	}{
+		{"", false, 0, false},
+		{"max-age=31536000", true, 31536000, false},
+		{"max-age=63072000; includeSubDomains; preload", true, 63072000, true},
+		{` Max-Age="300" ; INCLUDESUBDOMAINS`, true, 300, true},
+		{"max-age=0; includeSubDomains", false, 0, true},
+		{"includeSubDomains", false, 0, true},
+		{"max-age=soon", false, 0, false},
+	}
+
+	for _, test := range tests {
+		hsts, maxAge, includeSubDomains := parseHSTS(test.header)
+		if hsts != test.hsts || maxAge != test.maxAge || includeSubDomains != test.includeSubDomains {
+			t.Errorf("%q: got %v %v %v", test.header, hsts, maxAge, includeSubDomains)
+		}
+	}
+}
+
+// a transport that sends https requests for any host to tlsServer and http requests to plainServer, if any
+func testTransport(tlsServer *httptest.Server, plainServer *httptest.Server) http.RoundTripper {
+	dialer := &net.Dialer{}
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			_, port, _ := net.SplitHostPort(addr)
+			if port == "443" {
+				return dialer.DialContext(ctx, network, tlsServer.Listener.Addr().String())
+			}
+			if plainServer == nil {
+				return nil, fmt.Errorf("connection refused")
+			}
+			return dialer.DialContext(ctx, network, plainServer.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+}
+
+func TestAnalyzePrivacy(t *testing.T) {
+	type handlers struct {
+		autoconfigHTTPS http.HandlerFunc // https://autoconfig.example.com, 1.1
+		wellKnown       http.HandlerFunc // https://example.com, 1.2
+		autoconfigHTTP  http.HandlerFunc // http://autoconfig.example.com, 1.3, unreachable if nil
+	}
+	config := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mail/config-v1.1.xml" && r.URL.Path != "/.well-known/autoconfig/mail/config-v1.1.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(exampleConfig))
+	}
+	redirect := func(location string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+		}
+	}
+	hsts := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			next(w, r)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		handlers handlers
+		want     PrivacyResult
+	}{
+		{
+			name:     "https only",
+			handlers: handlers{autoconfigHTTPS: hsts(config)},
+			want:     PrivacyResult{HTTPSConfig: true, HSTS: true, HSTSMaxAge: 31536000, HSTSIncludeSubDomains: true},
+		},
+		{
+			name:     "https and a cleartext redirect to it",
+			handlers: handlers{autoconfigHTTPS: config, autoconfigHTTP: redirect("https://autoconfig.example.com/mail/config-v1.1.xml")},
+			want:     PrivacyResult{HTTPSConfig: true, HTTPReachable: true, HTTPRedirectsToHTTPS: true, RedirectLocation: "https://autoconfig.example.com/mail/config-v1.1.xml"},
+		},
+		{
+			name:     "well-known",
+			handlers: handlers{wellKnown: config},
+			want:     PrivacyResult{HTTPSConfig: true},
+		},
+		{
+			name:     "cleartext only",
+			handlers: handlers{autoconfigHTTP: config},
+			want:     PrivacyResult{HTTPConfig: true, HTTPOnly: true, HTTPReachable: true, AddressExposed: true},
+		},
+		{
+			// the redirect is only seen after the address went out in cleartext
+			name:     "cleartext redirect without an https config",
+			handlers: handlers{autoconfigHTTP: redirect("https://mail.example.net/")},
+			want:     PrivacyResult{HTTPReachable: true, HTTPRedirectsToHTTPS: true, RedirectLocation: "https://mail.example.net/", AddressExposed: true},
+		},
+		{
+			name:     "https redirected to cleartext",
+			handlers: handlers{autoconfigHTTPS: redirect("http://autoconfig.example.com/mail/config-v1.1.xml"), autoconfigHTTP: config},
+			want:     PrivacyResult{HTTPConfig: true, HTTPOnly: true, HTTPReachable: true, AddressExposed: true},
+		},
+		{
+			// the address is sent before the 404 comes back
+			name:     "cleartext host without a config",
+			handlers: handlers{autoconfigHTTP: http.NotFound},
+			want:     PrivacyResult{HTTPReachable: true, AddressExposed: true},
+		},
+		{
+			name:     "nothing",
+			handlers: handlers{},
+			want:     PrivacyResult{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := test.handlers
+			serve := func(handler http.HandlerFunc) func(http.ResponseWriter, *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if handler == nil {
+						http.NotFound(w, r)
+						return
+					}
+					handler(w, r)
+				}
+			}
+			tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Host == "example.com" {
+					serve(h.wellKnown)(w, r)
+				} else {
+					serve(h.autoconfigHTTPS)(w, r)
+				}
+			}))
+			defer tlsServer.Close()
+			var plainServer *httptest.Server
+			if h.autoconfigHTTP != nil {
+				plainServer = httptest.NewServer(h.autoconfigHTTP)
+				defer plainServer.Close()
+			}
+
+			a := New_PrivacyAnalyzer()
+			a.Transport = testTransport(tlsServer, plainServer)
+			result, err := a.Analyze("alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.want.EmailAddress, test.want.Domain = "alice@example.com", "example.com"
+			if *result != test.want {
+				t.Errorf("got  %+v\nwant %+v", *result, test.want)
+			}
+		})
+	}
+
+	report := Summarize_Privacy([]*PrivacyResult{
+		{HTTPSConfig: true, HSTS: true},
+		{HTTPConfig: true, HTTPOnly: true, AddressExposed: true},
+		nil,
+	})
+	if report != (PrivacyReport{Domains: 2, HTTPSConfig: 1, HTTPConfig: 1, HTTPOnly: 1, HSTS: 1, AddressExposed: 1}) {
+		t.Errorf("report %+v", report)
+	}
+}
@@ -0,0 +1,245 @@
+package autoconfig
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// the settings a client ends up with for one server after placeholders are expanded
+type EffectiveServer struct {
+	Type           string
+	Hostname       string
+	Port           int
+	URL            string // only for "jmap", "ews" and "activesync"
+	SocketType     string
+	Username       string
+	Authentication string // the first authentication method the client understands
+}
+
+type EffectiveSettings struct {
+	EmailAddress string
+	ProviderID   string
+	Incoming     *EffectiveServer  // nil if no usable incomingServer exists
+	Outgoing     *EffectiveServer  // nil if no usable outgoingServer exists
+	IncomingAlt  []EffectiveServer // the remaining usable incomingServers, in document order
+	OutgoingAlt  []EffectiveServer // the remaining usable outgoingServers, in document order
+}
+
+var knownAuthentication = map[string]bool{
+	"password-cleartext": true,
+	"password-encrypted": true,
+	"NTLM":               true,
+	"GSSAPI":             true,
+	"client-IP-address":  true,
+	"TLS-client-cert":    true,
+	"OAuth2":             true,
+	"smtp-after-pop":     true,
+	"none":               true,
+}
+
+var urlServerTypes = map[string]bool{
+	"jmap":       true,
+	"ews":        true,
+	"activesync": true,
+	"exchange":   true, // Thunderbird's legacy type, the urls are in owaURL, ewsURL and easURL
+}
+
+// replace %EMAILADDRESS%, %EMAILLOCALPART% and %EMAILDOMAIN% in s.
+// an address that doesn't parse is an error, the placeholders would otherwise end up in hostnames and usernames.
+func Expand_Placeholders(s string, email_address string) (string, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return "", err
+	}
+	return expandPlaceholders(s, addr), nil
+}
+
+func expandPlaceholders(s string, addr *utils.EmailAddress) string {
+	replacer := strings.NewReplacer(
+		"%EMAILADDRESS%", addr.String(),
+		"%EMAILLOCALPART%", addr.LocalPart,
+		"%EMAILDOMAIN%", addr.Domain,
+	)
+	return replacer.Replace(s)
+}
+
+// resolve the settings a client would use for email_address from a parsed config.
+// Servers are preferred in document order, servers that can't be used are skipped.
+func Resolve_EffectiveSettings(config *ClientConfig, email_address string) (*EffectiveSettings, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := config.Provider()
+	settings := &EffectiveSettings{
+		EmailAddress: email_address,
+		ProviderID:   expandPlaceholders(provider.ID, addr),
+	}
+
+	incoming := resolveServers(provider.IncomingServers, addr)
+	if len(incoming) > 0 {
+		settings.Incoming = &incoming[0]
+		settings.IncomingAlt = incoming[1:]
+	}
+
+	outgoing := resolveServers(provider.OutgoingServers, addr)
+	if len(outgoing) > 0 {
+		settings.Outgoing = &outgoing[0]
+		settings.OutgoingAlt = outgoing[1:]
+	}
+
+	if settings.Incoming == nil && settings.Outgoing == nil {
+		return settings, fmt.Errorf("no usable server in autoconfig for %v", email_address)
+	}
+
+	return settings, nil
+}
+
+func resolveServers(servers []Server, addr *utils.EmailAddress) []EffectiveServer {
+	resolved := make([]EffectiveServer, 0, len(servers))
+	for _, s := range servers {
+		server, err := resolveServer(s, addr)
+		if err != nil {
+			continue
+		}
+		resolved = append(resolved, server)
+	}
+	return resolved
+}
+
+func resolveServer(s Server, addr *utils.EmailAddress) (EffectiveServer, error) {
+	if urlServerTypes[s.Type] {
+		return resolveURLServer(s, addr)
+	}
+
+	hostname := strings.TrimSpace(expandPlaceholders(s.Hostname, addr))
+	if hostname == "" {
+		return EffectiveServer{}, fmt.Errorf("missing hostname")
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(s.Port))
+	if err != nil || port <= 0 || port > 65535 {
+		return EffectiveServer{}, fmt.Errorf("invalid port: %v", s.Port)
+	}
+
+	server := EffectiveServer{
+		Type:           s.Type,
+		Hostname:       hostname,
+		Port:           port,
+		SocketType:     strings.TrimSpace(s.SocketType),
+		Username:       strings.TrimSpace(expandPlaceholders(s.Username, addr)),
+		Authentication: preferredAuthentication(s.Authentication),
+	}
+
+	return server, nil
+}
+
+// servers of the mailmaint draft that are described by a url instead of hostname/port/socketType
+func resolveURLServer(s Server, addr *utils.EmailAddress) (EffectiveServer, error) {
+	rawurl := strings.TrimSpace(expandPlaceholders(serverURL(s), addr))
+	if rawurl == "" {
+		return EffectiveServer{}, fmt.Errorf("missing url")
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Hostname() == "" {
+		return EffectiveServer{}, fmt.Errorf("invalid url: %v", serverURL(s))
+	}
+
+	port := 443
+	socketType := "SSL"
+	if u.Scheme == "http" {
+		port = 80
+		socketType = "plain"
+	}
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return EffectiveServer{}, fmt.Errorf("invalid url: %v", serverURL(s))
+		}
+	}
+
+	server := EffectiveServer{
+		Type:           s.Type,
+		Hostname:       u.Hostname(),
+		Port:           port,
+		URL:            rawurl,
+		SocketType:     socketType,
+		Username:       strings.TrimSpace(expandPlaceholders(s.Username, addr)),
+		Authentication: preferredAuthentication(s.Authentication),
+	}
+
+	return server, nil
+}
+
+// the url of a url based server, "exchange" servers have no <url> and are reached through ewsURL, easURL or owaURL
+func serverURL(s Server) string {
+	if s.URL != "" || s.Type != "exchange" {
+		return s.URL
+	}
+	for _, u := range []string{s.EwsURL, s.EasURL, s.OwaURL} {
+		if strings.TrimSpace(u) != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+func preferredAuthentication(methods []string) string {
+	for _, auth := range methods {
+		auth = strings.TrimSpace(auth)
+		if knownAuthentication[auth] {
+			return auth
+		}
+	}
+	return ""
+}
+
+// the resolved settings in the model shared with Autodiscover and SRV.
+// only IMAP, POP3 and SMTP servers are kept, in the order a client would try them.
+func (s *EffectiveSettings) MailSettings() *utils.MailSettings {
+	settings := &utils.MailSettings{Source: utils.SourceAutoconfig, EmailAddress: s.EmailAddress}
+
+	servers := make([]EffectiveServer, 0)
+	for _, server := range []*EffectiveServer{s.Incoming, s.Outgoing} {
+		if server != nil {
+			servers = append(servers, *server)
+		}
+	}
+	servers = append(servers, s.IncomingAlt...)
+	servers = append(servers, s.OutgoingAlt...)
+
+	for _, server := range servers {
+		protocol := utils.MailProtocol(server.Type)
+		if protocol != utils.MailIMAP && protocol != utils.MailPOP3 && protocol != utils.MailSMTP {
+			continue
+		}
+		settings.Add(utils.MailServer{
+			Protocol:       protocol,
+			Hostname:       strings.ToLower(strings.TrimSuffix(server.Hostname, ".")),
+			Port:           server.Port,
+			Security:       utils.Security_FromSocketType(server.SocketType),
+			Username:       server.Username,
+			Authentication: server.Authentication,
+		})
+	}
+	return settings
+}
+
+// parse an autoconfig file and resolve it into the shared model for email_address
+func Load_MailSettings(xmlpath string, email_address string) (*utils.MailSettings, error) {
+	config, err := Load_AutoconfigXML(xmlpath)
+	if err != nil {
+		return nil, err
+	}
+	effective, err := Resolve_EffectiveSettings(config, email_address)
+	if err != nil {
+		return nil, err
+	}
+	return effective.MailSettings(), nil
+}
@@ -0,0 +1,157 @@
+package autoconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+const exampleConfig = `<?xml version="1.0" encoding="UTF-8"?>
+<clientConfig version="1.1">
+  <emailProvider id="%EMAILDOMAIN%">
+    <domain>example.com</domain>
+    <displayName>Example Mail</displayName>
+    <incomingServer type="imap">
+      <hostname>imap.%EMAILDOMAIN%</hostname>
+      <port>993x</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILADDRESS%</username>
+      <authentication>password-cleartext</authentication>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.%EMAILDOMAIN%</hostname>
+      <port>993</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILADDRESS%</username>
+      <authentication>unknown-method</authentication>
+      <authentication>OAuth2</authentication>
+      <authentication>password-cleartext</authentication>
+    </incomingServer>
+    <incomingServer type="pop3">
+      <hostname>pop.example.com</hostname>
+      <port>110</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILLOCALPART%</username>
+      <authentication>password-encrypted</authentication>
+    </incomingServer>
+    <incomingServer type="jmap">
+      <url>https://jmap.%EMAILDOMAIN%:8443/.well-known/jmap</url>
+      <username>%EMAILADDRESS%</username>
+      <authentication>OAuth2</authentication>
+    </incomingServer>
+    <incomingServer type="exchange">
+      <hostname>outlook.example.com</hostname>
+      <owaURL>https://outlook.example.com/owa/</owaURL>
+      <ewsURL>https://outlook.example.com/ews/exchange.asmx</ewsURL>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>smtp.%EMAILDOMAIN%</hostname>
+      <port>587</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILADDRESS%</username>
+      <authentication>password-cleartext</authentication>
+    </outgoingServer>
+  </emailProvider>
+</clientConfig>`
+
+func TestParseAutoconfigXML(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantErr  bool
+		id       string
+		incoming int
+		outgoing int
+	}{
+		{"example", exampleConfig, false, "%EMAILDOMAIN%", 5, 1},
+		{"empty provider", `<clientConfig version="1.1"><emailProvider id="x"/></clientConfig>`, false, "x", 0, 0},
+		{"malformed", `<clientConfig><emailProvider>`, true, "", 0, 0},
+		{"html", `<html><body>not found</body></html>`, true, "", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := Parse_AutoconfigXML([]byte(test.data))
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("no error, parsed %+v", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			provider := config.Provider()
+			if provider.ID != test.id || len(provider.IncomingServers) != test.incoming || len(provider.OutgoingServers) != test.outgoing {
+				t.Errorf("parsed %+v", *provider)
+			}
+		})
+	}
+}
+
+func TestResolveEffectiveSettings(t *testing.T) {
+	config, err := Parse_AutoconfigXML([]byte(exampleConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := Resolve_EffectiveSettings(config, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ProviderID != "example.com" {
+		t.Errorf("provider id %q", settings.ProviderID)
+	}
+
+	// the first imap server has an invalid port and is skipped
+	want := &EffectiveServer{Type: "imap", Hostname: "imap.example.com", Port: 993, SocketType: "SSL", Username: "alice@example.com", Authentication: "OAuth2"}
+	if !reflect.DeepEqual(settings.Incoming, want) {
+		t.Errorf("incoming %+v, want %+v", settings.Incoming, want)
+	}
+	alt := []EffectiveServer{
+		{Type: "pop3", Hostname: "pop.example.com", Port: 110, SocketType: "STARTTLS", Username: "alice", Authentication: "password-encrypted"},
+		{Type: "jmap", Hostname: "jmap.example.com", Port: 8443, URL: "https://jmap.example.com:8443/.well-known/jmap", SocketType: "SSL", Username: "alice@example.com", Authentication: "OAuth2"},
+		{Type: "exchange", Hostname: "outlook.example.com", Port: 443, URL: "https://outlook.example.com/ews/exchange.asmx", SocketType: "SSL"},
+	}
+	if !reflect.DeepEqual(settings.IncomingAlt, alt) {
+		t.Errorf("incoming alternatives %+v, want %+v", settings.IncomingAlt, alt)
+	}
+	if settings.Outgoing == nil || settings.Outgoing.Hostname != "smtp.example.com" || settings.Outgoing.Port != 587 || len(settings.OutgoingAlt) != 0 {
+		t.Errorf("outgoing %+v %+v", settings.Outgoing, settings.OutgoingAlt)
+	}
+
+	mail := settings.MailSettings()
+	if len(mail.Incoming) != 2 || len(mail.Outgoing) != 1 || mail.Incoming[1].String() != "pop3 pop.example.com:110 (starttls)" {
+		t.Errorf("mail settings %+v", mail)
+	}
+}
+
+func TestResolveEffectiveSettingsErrors(t *testing.T) {
+	config, err := Parse_AutoconfigXML([]byte(exampleConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve_EffectiveSettings(config, "not an address"); err == nil {
+		t.Error("no error for an invalid address")
+	}
+
+	unusable, err := Parse_AutoconfigXML([]byte(`<clientConfig version="1.1"><emailProvider id="example.com">
+		<incomingServer type="imap"><hostname></hostname><port>993</port></incomingServer>
+		<outgoingServer type="smtp"><hostname>smtp.example.com</hostname><port>0</port></outgoingServer>
+	</emailProvider></clientConfig>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve_EffectiveSettings(unusable, "alice@example.com"); err == nil {
+		t.Error("no error for a config without usable servers")
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	s, err := Expand_Placeholders("%EMAILLOCALPART%@mail.%EMAILDOMAIN% (%EMAILADDRESS%)", "bob@bücher.example")
+	if err != nil || s != "bob@mail.bücher.example (bob@bücher.example)" {
+		t.Errorf("expanded %q, %v", s, err)
+	}
+	if s, err := Expand_Placeholders("imap.%EMAILDOMAIN%", "bob"); err == nil {
+		t.Errorf("no error for an invalid address, expanded %q", s)
+	}
+}
@@ -0,0 +1,115 @@
+package autoconfig
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// server types of config-v1.1 and the IETF mailmaint draft, as reported by `Get_ServerTypes`
+var ServerTypes = []string{"imap", "pop3", "smtp", "jmap", "ews", "activesync", "caldav", "carddav", "webdav", "oauth2"}
+
+// the server types a config advertises, "oauth2" is reported if any server offers OAuth2
+func Get_ServerTypes(config *ClientConfig) []string {
+	found := make(map[string]bool)
+	provider := config.Provider()
+
+	servers := append(append([]Server{}, provider.IncomingServers...), provider.OutgoingServers...)
+	for _, s := range servers {
+		switch strings.ToLower(s.Type) {
+		case "exchange":
+			// Thunderbird's legacy type carries the ews/activesync urls as extra elements
+			if s.EwsURL != "" {
+				found["ews"] = true
+			}
+			if s.EasURL != "" {
+				found["activesync"] = true
+			}
+		case "":
+		default:
+			found[strings.ToLower(s.Type)] = true
+		}
+
+		for _, auth := range s.Authentication {
+			if strings.TrimSpace(auth) == "OAuth2" {
+				found["oauth2"] = true
+			}
+		}
+	}
+
+	services := append(append(append([]Service{}, provider.AddressBooks...), provider.Calendars...), provider.FileShares...)
+	for _, s := range services {
+		if s.Type != "" {
+			found[strings.ToLower(s.Type)] = true
+		}
+	}
+
+	if config.OAuth2 != nil {
+		found["oauth2"] = true
+	}
+
+	types := make([]string, 0, len(found))
+	for t := range found {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func Has_ServerType(config *ClientConfig, serverType string) bool {
+	for _, t := range Get_ServerTypes(config) {
+		if t == serverType {
+			return true
+		}
+	}
+	return false
+}
+
+type ServerTypeStats struct {
+	Files   int            // xml files in the directory
+	Parsed  int            // files that are autoconfig documents
+	Domains map[string]int // server type -> number of domains advertising it
+}
+
+// count how many domains advertise each server type in a directory of files saved by `Download_AutoconfigXML`
+func Count_ServerTypes(dir string) (*ServerTypeStats, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ServerTypeStats{Files: len(files), Domains: make(map[string]int)}
+	seen := make(map[string]map[string]bool) // server type -> domains
+
+	for _, file := range files {
+		config, err := Load_AutoconfigXML(file)
+		if err != nil || config.Provider().ID == "" && len(config.Provider().IncomingServers) == 0 {
+			continue
+		}
+		stats.Parsed++
+
+		domain := strings.TrimSuffix(filepath.Base(file), ".xml")
+		if addr, err := utils.Parse_EmailAddress(domain); err == nil {
+			domain = addr.ASCIIDomain
+		}
+
+		for _, t := range Get_ServerTypes(config) {
+			if seen[t] == nil {
+				seen[t] = make(map[string]bool)
+			}
+			seen[t][domain] = true
+		}
+	}
+
+	for t, domains := range seen {
+		stats.Domains[t] = len(domains)
+	}
+
+	if stats.Parsed == 0 && stats.Files > 0 {
+		return stats, fmt.Errorf("no autoconfig file could be parsed in %v", dir)
+	}
+	return stats, nil
+}
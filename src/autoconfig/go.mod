@@ -1,3 +1,17 @@
 module github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig
 
 go 1.22.3
+
+require github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils v1.0.0
+
+require (
+	github.com/miekg/dns v1.1.62 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+)
+
+replace github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils => ../utils
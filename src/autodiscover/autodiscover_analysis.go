@@ -5,224 +5,474 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
 )
 
 // Autodiscover struct
 type Autodiscover struct {
-	XMLName  xml.Name `xml:"Autodiscover"`
-	Request  Request  `xml:"Request,omitempty"`
-	Response Response `xml:"Response,omitempty"`
-	XMLNS    string   `xml:"xmlns,attr"`
+	XMLName  xml.Name  `xml:"Autodiscover"`
+	Request  *Request  `xml:"Request,omitempty"`
+	Response *Response `xml:"Response,omitempty"`
+	XMLNS    string    `xml:"xmlns,attr"`
 }
 
 type Request struct {
 	AcceptableResponseSchema string `xml:"AcceptableResponseSchema"`
-	EmailAddress             string `xml:"EmailAddress,omitempty"`
+	EmailAddress             string `xml:"EMailAddress,omitempty"` // MS-OXDSCLI 2.2.3.1.1.1, the element name is case sensitive
 	LegacyDN                 string `xml:"LegacyDN,omitempty"`
 }
 
+// MS-OXDSCLI 2.2.4.1.1, Account is absent in error responses and User in redirects
 type Response struct {
-	User    User    `xml:"User,omitempty"`
-	Account Account `xml:"Account"`
-	Error   Error   `xml:"Error,omitempty"`
+	XMLNS   string   `xml:"xmlns,attr,omitempty"` // ".../outlook/responseschema/2006a", error responses inherit the namespace of Autodiscover
+	User    *User    `xml:"User,omitempty"`
+	Account *Account `xml:"Account,omitempty"`
+	Error   *Error   `xml:"Error,omitempty"` // MS-OXDSCLI 2.2.4.2, nil unless the server returned an error response
 }
 
 type User struct {
-	AutoDiscoverSMTPAddress string `xml:"AutoDiscoverSMTPAddress"`
-	DefaultABView           string `xml:"DefaultABView,omitempty"`
-	DeploymentId            string `xml:"DeploymentId"`
+	AutoDiscoverSMTPAddress string `xml:"AutoDiscoverSMTPAddress,omitempty"`
+	DefaultABView           string `xml:"DefaultABView,omitempty"` // address book view shown first, a LegacyDN
+	DeploymentId            string `xml:"DeploymentId,omitempty"`
 	DisplayName             string `xml:"DisplayName"`
-	LegacyDN                string `xml:"LegacyDN"`
+	LegacyDN                string `xml:"LegacyDN,omitempty"`
 }
 
 type Account struct {
-	AccountType             string                  `xml:"AccountType,omitempty"`     // if contained, it should be "email"
-	Action                  string                  `xml:"Action"`                    // it's value belongs to {"settings", "redirectUrl", "redirectAddr"}
-	MicrosoftOnline         string                  `xml:"MicrosoftOnline,omitempty"` // is required when Action is "settings", The value SHOULD be "False".
-	ConsumerMailbox         string                  `xml:"ConsumerMailbox,omitempty"` // is required when Action is "settings", The value SHOULD be "False".
-	AlternativeMailbox      AlternativeMailbox      `xml:"AlternativeMailbox,omitempty"`
-	Protocol                Protocol                `xml:"Protocol,omitempty"`
-	PublicFolderInformation PublicFolderInformation `xml:"PublicFolderInformation,omitempty"`
-	RedirectAddr            string                  `xml:"RedirectAddr,omitempty"`
-	RedirectUrl             string                  `xml:"RedirectUrl,omitempty"`
+	AccountType             string                   `xml:"AccountType,omitempty"`     // if contained, it should be "email"
+	Action                  string                   `xml:"Action"`                    // it's value belongs to {"settings", "redirectUrl", "redirectAddr"}
+	MicrosoftOnline         string                   `xml:"MicrosoftOnline,omitempty"` // is required when Action is "settings", The value SHOULD be "False".
+	ConsumerMailbox         string                   `xml:"ConsumerMailbox,omitempty"` // is required when Action is "settings", The value SHOULD be "False".
+	AlternativeMailbox      []AlternativeMailbox     `xml:"AlternativeMailbox,omitempty"`
+	Protocol                []Protocol               `xml:"Protocol,omitempty"`
+	PublicFolderInformation *PublicFolderInformation `xml:"PublicFolderInformation,omitempty"`
+	RedirectAddr            string                   `xml:"RedirectAddr,omitempty"`
+	RedirectUrl             string                   `xml:"RedirectUrl,omitempty"`
 }
 
 type Error struct {
 	Time      string `xml:"Time,attr"`
 	Id        string `xml:"Id,attr"`
 	DebugData string `xml:"DebugData"`
-	Errorcode int    `xml:"Errorcode"`
+	ErrorCode string `xml:"ErrorCode"` // see `ErrorCodes`, kept as text because servers don't always send a number
 	Message   string `xml:"Message"`
 }
 
+// a mailbox the user can open besides their own
 type AlternativeMailbox struct {
-	DisplayName  string `xml:"DisplayName"`
-	EmailAddress string `xml:"EmailAddress,omitempty"`
-	Server       string `xml:"Server,omitempty"`
-	SmtpAddress  string `xml:"SmtpAddress,omitempty"`
-	Type         string `xml:"Type"` // it's value belongs to {"Archiv", "Delegate", "TeamMailbox}
+	Type             string `xml:"Type"` // it's value belongs to {"Archive", "Delegate", "TeamMailbox"}
+	DisplayName      string `xml:"DisplayName"`
+	LegacyDN         string `xml:"LegacyDN,omitempty"`
+	Server           string `xml:"Server,omitempty"`
+	SmtpAddress      string `xml:"SmtpAddress,omitempty"`
+	OwnerSmtpAddress string `xml:"OwnerSmtpAddress,omitempty"`
 }
 
+// one way to reach the mailbox.
+// classic responses name the protocol in the Type element ("EXCH", "EXPR", "WEB", "POP3", "IMAP", "SMTP"),
+// MAPI/HTTP responses in the Type attribute ("mapiHttp").
 type Protocol struct {
+	TypeAttribute string            `xml:"Type,attr,omitempty"`
+	Version       string            `xml:"Version,attr,omitempty"` // MAPI/HTTP protocol version
+	MailStore     *MapiHttpEndpoint `xml:"MailStore,omitempty"`
+	AddressBook   *MapiHttpEndpoint `xml:"AddressBook,omitempty"`
+
+	Type                   string `xml:"Type,omitempty"`
+	Internal               *Site  `xml:"Internal,omitempty"` // only for "WEB"
+	External               *Site  `xml:"External,omitempty"` // only for "WEB"
+	Server                 string `xml:"Server,omitempty"`
+	ServerDN               string `xml:"ServerDN,omitempty"`
+	ServerVersion          string `xml:"ServerVersion,omitempty"` // hex, e.g. "73C18880" for Exchange 2013
+	MdbDN                  string `xml:"MdbDN,omitempty"`
+	PublicFolderServer     string `xml:"PublicFolderServer,omitempty"`
+	AD                     string `xml:"AD,omitempty"`
+	Port                   string `xml:"Port,omitempty"`
+	DirectoryPort          string `xml:"DirectoryPort,omitempty"`
+	ReferralPort           string `xml:"ReferralPort,omitempty"`
+	DomainRequired         string `xml:"DomainRequired,omitempty"` // "on" or "off"
+	DomainName             string `xml:"DomainName,omitempty"`
+	LoginName              string `xml:"LoginName,omitempty"`
+	SPA                    string `xml:"SPA,omitempty"`        // "on" or "off", secure password authentication
+	SSL                    string `xml:"SSL,omitempty"`        // "on" or "off"
+	Encryption             string `xml:"Encryption,omitempty"` // "None", "SSL", "TLS" or "Auto", takes precedence over SSL
+	AuthPackage            string `xml:"AuthPackage,omitempty"`
+	AuthRequired           string `xml:"AuthRequired,omitempty"`
+	CertPrincipalName      string `xml:"CertPrincipalName,omitempty"`
+	ServerExclusiveConnect string `xml:"ServerExclusiveConnect,omitempty"`
+	UsePOPAuth             string `xml:"UsePOPAuth,omitempty"`
+	SMTPLast               string `xml:"SMTPLast,omitempty"`
+	TTL                    string `xml:"TTL,omitempty"` // hours the settings can be cached
+	ASUrl                  string `xml:"ASUrl,omitempty"`
+	EwsUrl                 string `xml:"EwsUrl,omitempty"`
+	EmwsUrl                string `xml:"EmwsUrl,omitempty"`
+	EwsPartnerUrl          string `xml:"EwsPartnerUrl,omitempty"`
+	SharingUrl             string `xml:"SharingUrl,omitempty"`
+	EcpUrl                 string `xml:"EcpUrl,omitempty"`
+	EcpUrlUm               string `xml:"EcpUrl-um,omitempty"`
+	EcpUrlAggr             string `xml:"EcpUrl-aggr,omitempty"`
+	EcpUrlMt               string `xml:"EcpUrl-mt,omitempty"`
+	EcpUrlRet              string `xml:"EcpUrl-ret,omitempty"`
+	EcpUrlSms              string `xml:"EcpUrl-sms,omitempty"`
+	EcpUrlPublish          string `xml:"EcpUrl-publish,omitempty"`
+	EcpUrlPhoto            string `xml:"EcpUrl-photo,omitempty"`
+	EcpUrlTm               string `xml:"EcpUrl-tm,omitempty"`
+	EcpUrlTmCreating       string `xml:"EcpUrl-tmCreating,omitempty"`
+	EcpUrlTmEditing        string `xml:"EcpUrl-tmEditing,omitempty"`
+	EcpUrlTmHiding         string `xml:"EcpUrl-tmHiding,omitempty"`
+	EcpUrlExtinstall       string `xml:"EcpUrl-extinstall,omitempty"`
+	OOFUrl                 string `xml:"OOFUrl,omitempty"`
+	UMUrl                  string `xml:"UMUrl,omitempty"`
+	OABUrl                 string `xml:"OABUrl,omitempty"`
+	SiteMailboxCreationURL string `xml:"SiteMailboxCreationURL,omitempty"`
+	GroupingInformation    string `xml:"GroupingInformation,omitempty"`
+}
+
+// the Outlook Web Access and ActiveSync urls of a "WEB" protocol
+type Site struct {
+	OWAUrl   []OWAUrl      `xml:"OWAUrl,omitempty"`
+	Protocol *SiteProtocol `xml:"Protocol,omitempty"`
+}
+
+type OWAUrl struct {
+	AuthenticationMethod string `xml:"AuthenticationMethod,attr,omitempty"` // e.g. "Basic", "Fba", "WindowsIntegrated"
+	URL                  string `xml:",chardata"`
+}
+
+type SiteProtocol struct {
+	Type  string `xml:"Type,omitempty"`
+	ASUrl string `xml:"ASUrl,omitempty"`
 }
 
 type PublicFolderInformation struct {
 	SmtpAddress string `xml:"SmtpAddress"`
 }
 
-func Download_AutodiscoverXML(email_address string, path string) error {
-	// download the url_list's XML file to path
-	xmlpath := filepath.Join(path, email_address+".xml")
+// the settings a discovery ended with, after following redirects
+type Discovery struct {
+	URL          string   // the url that returned the settings
+	EmailAddress string   // the address the settings are for, differs from the requested one after a redirectAddr
+	Redirects    []string // every url and address redirected to, in order
+	Body         []byte
+	Autodiscover *Autodiscover
+}
 
-	dir := filepath.Dir(xmlpath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("error creating directory: %v", dir)
+// find the settings for email_address, trying the candidates in the order the given client would
+func (c *Client) Discover(email_address string, behavior ClientBehavior) (*Discovery, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
 	}
+	return c.Discover_Candidates(email_address, Get_AutodiscoverCandidates(addr, behavior))
+}
 
-	parts := strings.Split(email_address, "@")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid email address: %v", email_address)
+// like `Discover`, but tries the given candidates in order, e.g. a client's own selection of `Get_AutodiscoverCandidates`
+func (c *Client) Discover_Candidates(email_address string, candidates []Candidate) (*Discovery, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
 	}
+	email_address = addr.ASCII()
+
+	attempts := make([]error, 0, len(candidates))
+	for _, candidate := range candidates {
+		// RFC 6186 section 6, credentials only go to SRV targets outside the domain if DNSSEC vouches for them
+		if c.Credentials != nil && candidate.SRV != nil && candidate.SRV.Class == utils.SRVThirdParty {
+			attempts = append(attempts, fmt.Errorf("%v: not sending credentials to %v, an unverified third party", candidate.Section, candidate.SRV.Target))
+			continue
+		}
 
-	email_domain := parts[1]
-	// email_local := parts[0]
+		var d *Discovery
+		var err error
+		if candidate.Method == http.MethodGet {
+			d, err = c.Get_Autodiscover(candidate.URL, email_address)
+		} else {
+			d, err = c.Post_Autodiscover(candidate.URL, email_address)
+		}
+		if err == nil {
+			return d, nil
+		}
+		attempts = append(attempts, fmt.Errorf("%v: %w", candidate.Section, err))
+	}
 
-	url_list := make([]string, 0)
+	return nil, &utils.NotFoundError{Mechanism: "Autodiscoverxml", EmailAddress: email_address, Attempts: attempts}
+}
 
-	// MS-OXDISCO 3.1.5.1
-	// 目前没有实现
+// download the Autodiscover XML file for email_address to path, trying the candidates in the order the given client would
+func (c *Client) Download_AutodiscoverXML(email_address string, path string, behavior ClientBehavior) error {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return err
+	}
 
-	// MS-OXDISCO 3.1.5.2 POST maybe there exists redirect
-	url_2_1 := "http://" + email_domain + "/Autodiscover/Autodiscover.xml"
-	url_list = append(url_list, url_2_1)
-	url_2_2 := "https://Autodiscover." + email_domain + "/Autodiscover/Autodiscover.xml"
-	url_list = append(url_list, url_2_2)
+	d, err := c.Discover(email_address, behavior)
+	if err != nil {
+		return err
+	}
+	return saveAutodiscoverXML(filepath.Join(path, addr.FileName()+".xml"), d.Body)
+}
 
-	// MS-OXDISCO 3.1.5.3
-	_, srv, err := net.LookupSRV("autodiscover", "tcp", email_domain)
-	if err == nil {
-		for _, s := range srv {
-			url_3_1 := "https://" + strings.Trim(s.Target, ".") + "/Autodiscover/Autodiscover.xml"
-			url_list = append(url_list, url_3_1)
-		}
+func (c *Client) Post_Autodiscoverxml(url string, xmlpath string, email_address string) error {
+	d, err := c.Post_Autodiscover(url, email_address)
+	if err != nil {
+		return err
 	}
+	return saveAutodiscoverXML(xmlpath, d.Body)
+}
 
-	for _, url := range url_list {
-		err := Post_Autodiscoverxml(url, xmlpath, email_address)
-		if err == nil {
-			return nil
-		}
+func (c *Client) Get_AutodiscoverXML(url string, xmlpath string, email_address string) error {
+	d, err := c.Get_Autodiscover(url, email_address)
+	if err != nil {
+		return err
 	}
+	return saveAutodiscoverXML(xmlpath, d.Body)
+}
 
-	// MS-OXDISCO 3.1.5.4
-	url_4_1 := "http://Autodiscover." + email_domain + "/Autodiscover/Autodiscover.xml"
+// POST an Autodiscover request for email_address to url and follow the redirects of MS-OXDSCLI 3.1.5.2 and 3.1.5.3
+func (c *Client) Post_Autodiscover(url string, email_address string) (*Discovery, error) {
+	d := &Discovery{}
+	err := c.post(url, email_address, d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
 
-	err = Get_AutodiscoverXML(url_4_1, xmlpath, email_address)
-	if err == nil {
-		return nil
+// MS-OXDISCO 3.1.5.4, GET url and POST the request to the https url it redirects to
+func (c *Client) Get_Autodiscover(url string, email_address string) (*Discovery, error) {
+	response, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, url, nil)
+	})
+	if err != nil {
+		return nil, err
 	}
+	defer response.Body.Close()
 
-	return fmt.Errorf("can't find Autodiscoverxml file for %v", email_address)
+	if response.StatusCode == http.StatusOK {
+		d := &Discovery{}
+		if err := c.readSettings(http.MethodGet, url, email_address, response, d); err != nil {
+			return nil, err
+		}
+		return d, nil
+	} else if isRedirect(response.StatusCode) {
+		location, err := response.Location()
+		if err != nil {
+			return nil, err
+		}
+		// the redirect comes over plain http, only an https target can be trusted with the address
+		if location.Scheme != "https" {
+			return nil, fmt.Errorf("refusing redirect to %v, not https", location)
+		}
+		d := &Discovery{}
+		if err := c.redirect(d, location.String()); err != nil {
+			return nil, err
+		}
+		err = c.post(location.String(), email_address, d)
+		if err != nil {
+			return nil, followRedirect(url, location.String(), err)
+		}
+		return d, nil
+	} else if response.StatusCode == http.StatusUnauthorized {
+		return nil, &AuthRequiredError{Endpoint: c.Endpoints[len(c.Endpoints)-1]}
+	}
+
+	return nil, fmt.Errorf("error downloading file: %v use GET", url)
 }
 
-func Post_Autodiscoverxml(url string, xmlpath string, email_address string) error {
-	// MS-OXDSCLI 2.2.3.1.1.3 LegacyDN is not implemented
-	request := Autodiscover{
-		Request: Request{
-			AcceptableResponseSchema: "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a",
-			EmailAddress:             email_address,
-		},
-		XMLNS: "http://schemas.microsoft.com/exchange/autodiscover/outlook/requestschema/2006",
+// POST the request and fill d, d.Redirects counts the redirects followed so far
+func (c *Client) post(url string, email_address string, d *Discovery) error {
+	requestbyte, err := autodiscoverRequest(email_address)
+	if err != nil {
+		return err
 	}
 
-	requestbyte, err := xml.Marshal(request)
+	resp, err := c.postRequest(url, email_address, requestbyte)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return c.readSettings(http.MethodPost, url, email_address, resp, d)
+	} else if isRedirect(resp.StatusCode) {
+		// relative to the url that was requested
+		location, err := resp.Location()
+		if err != nil {
+			return err
+		}
+		// the address is POSTed again to the target, only https keeps it from the wire
+		if location.Scheme != "https" {
+			return fmt.Errorf("refusing redirect to %v, not https", location)
+		}
+		if err := c.redirect(d, location.String()); err != nil {
+			return err
+		}
+		return followRedirect(url, location.String(), c.post(location.String(), email_address, d))
+	} else if resp.StatusCode == http.StatusUnauthorized {
+		return &AuthRequiredError{Endpoint: c.Endpoints[len(c.Endpoints)-1]}
+	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestbyte))
+	return fmt.Errorf("error downloading file: %v use POST", url)
+}
+
+// read the HTTP 200 answer to a GET or POST of url and fill d, following the redirects of MS-OXDSCLI 3.1.5.3
+func (c *Client) readSettings(method string, url string, email_address string, resp *http.Response, d *Discovery) error {
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", "text/xml")
-	// Set Http Header
-	if false {
-		req.Header.Set("X-MapiHttpCapability", "1") // greater than 0
-		req.Header.Set("X-AnchorMailbox", email_address)
+	class := c.classifyResponse(method, url, resp, body)
+	if class.Class != utils.ResponseValid {
+		return &utils.RejectedResponseError{URL: url, Classification: class}
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	var AD Autodiscover
+	err = xml.Unmarshal(body, &AD)
+	if err != nil {
+		return err
+	}
 
-	if resp.StatusCode == http.StatusOK {
+	// MS-OXDSCLI 2.2.4.2, error responses are not configs
+	if AD.Response != nil && AD.Response.Error != nil {
+		return &ResponseError{URL: url, Detail: *AD.Response.Error}
+	}
+	if AD.Response == nil || AD.Response.Account == nil {
+		return fmt.Errorf("no Account in response from %v", url)
+	}
 
-		var AD Autodiscover
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
+	// MS-OXDSCLI 3.1.5.3, a redirect is only taken when Action says so.
+	// both POST an address again, only https keeps it from the wire.
+	account := AD.Response.Account
+	switch {
+	case account.Action == "redirectAddr" && account.RedirectAddr != "":
+		if !strings.HasPrefix(strings.ToLower(url), "https://") {
+			return fmt.Errorf("refusing to send %v to %v, not https", account.RedirectAddr, url)
 		}
-
-		err = xml.Unmarshal(body, &AD)
-		if err != nil {
+		if err := c.redirect(d, account.RedirectAddr); err != nil {
 			return err
 		}
-
-		// MS-OXDSCLI 3.1.5.3
-		if AD.Response.Account.RedirectAddr != "" {
-			return Post_Autodiscoverxml(url, xmlpath, AD.Response.Account.RedirectAddr)
-		} else if AD.Response.Account.RedirectUrl != "" {
-			return Post_Autodiscoverxml(AD.Response.Account.RedirectUrl, xmlpath, email_address)
+		return followRedirect(url, account.RedirectAddr, c.post(url, account.RedirectAddr, d))
+	case account.Action == "redirectUrl" && account.RedirectUrl != "":
+		if !strings.HasPrefix(strings.ToLower(account.RedirectUrl), "https://") {
+			return fmt.Errorf("refusing redirect to %v, not https", account.RedirectUrl)
 		}
-
-		outFile, err := os.Create(xmlpath)
-		if err != nil {
-			return fmt.Errorf("error creating file: %v", err)
+		if err := c.redirect(d, account.RedirectUrl); err != nil {
+			return err
 		}
-		defer outFile.Close()
+		return followRedirect(url, account.RedirectUrl, c.post(account.RedirectUrl, email_address, d))
+	}
 
-		_, err = io.Copy(outFile, resp.Body)
-		if err != nil {
-			return fmt.Errorf("error saving to file: %v", xmlpath)
-		}
+	d.URL = url
+	d.EmailAddress = email_address
+	d.Body = body
+	d.Autodiscover = &AD
+	return nil
+}
 
-		return nil
-	} else if resp.StatusCode == http.StatusFound {
-		return Post_Autodiscoverxml(resp.Header.Get("Location"), xmlpath, email_address)
+// the address POSTed to the random path of the soft-404 probe, the user's address stays with the real endpoint
+const probeEmailAddress = "nobody@example.com"
+
+// check a HTTP 200 response is an Autodiscover document and not a catch-all page of the host.
+// the random path is only requested over https, and with `probeEmailAddress`.
+func (c *Client) classifyResponse(method string, rawurl string, resp *http.Response, body []byte) utils.Classification {
+	class := utils.Classify_Response(resp.Header.Get("Content-Type"), body, "Autodiscover")
+	if !utils.Needs_SoftNotFoundProbe(class) || !strings.HasPrefix(strings.ToLower(rawurl), "https://") {
+		return class
 	}
 
-	return fmt.Errorf("error downloading file: %v use POST", url)
+	contentType, requestbyte := "", []byte(nil)
+	if method == http.MethodPost {
+		contentType = "text/xml"
+		var err error
+		if requestbyte, err = autodiscoverRequest(probeEmailAddress); err != nil {
+			return class
+		}
+	}
+	probe, err := utils.Probe_RandomPath(c.HTTPClient, method, rawurl, contentType, requestbyte)
+	if err == nil {
+		class = utils.Check_SoftNotFound(class, body, probe)
+	}
+	return class
 }
 
-func Get_AutodiscoverXML(url string, xmlpath string, email_address string) error {
-	response, err := http.Get(url)
-	if err != nil {
-		return err
+// the body of an Autodiscover request for email_address
+func autodiscoverRequest(email_address string) ([]byte, error) {
+	// MS-OXDSCLI 2.2.3.1.1.3 LegacyDN is not implemented
+	request := Autodiscover{
+		Request: &Request{
+			AcceptableResponseSchema: "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a",
+			EmailAddress:             email_address,
+		},
+		XMLNS: "http://schemas.microsoft.com/exchange/autodiscover/outlook/requestschema/2006",
 	}
-	defer response.Body.Close()
+	return xml.Marshal(request)
+}
 
-	if response.StatusCode == http.StatusOK {
-		outFile, err := os.Create(xmlpath)
+func (c *Client) postRequest(url string, email_address string, requestbyte []byte) (*http.Response, error) {
+	return c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestbyte))
 		if err != nil {
-			return fmt.Errorf("error creating file: %v", err)
+			return nil, err
 		}
 
-		defer outFile.Close()
+		req.Header.Set("Content-Type", "text/xml")
+		c.setMapiHttpHeaders(req, email_address)
+		return req, nil
+	})
+}
 
-		_, err = io.Copy(outFile, response.Body)
-		if err != nil {
-			return fmt.Errorf("error saving to file: %v", xmlpath)
+// record a redirect to target, MS-OXDSCLI 3.1.5.3 says to limit them and to stop at loops
+func (c *Client) redirect(d *Discovery, target string) error {
+	for _, r := range d.Redirects {
+		if r == target {
+			return fmt.Errorf("redirect loop at %v", target)
 		}
+	}
+	if len(d.Redirects) >= c.maxRedirects() {
+		return fmt.Errorf("too many redirects, stopped at %v", target)
+	}
+	d.Redirects = append(d.Redirects, target)
+	return nil
+}
 
-		return nil
-	} else if response.StatusCode == http.StatusFound {
-		return Post_Autodiscoverxml(response.Header.Get("Location"), xmlpath, email_address)
+// the HTTP redirects the client handles itself, it doesn't let net/http follow them (`noRedirect`)
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
 	}
+	return false
+}
+
+func (c *Client) maxRedirects() int {
+	if c.MaxRedirects > 0 {
+		return c.MaxRedirects
+	}
+	return DefaultMaxRedirects
+}
+
+func saveAutodiscoverXML(xmlpath string, body []byte) error {
+	dir := filepath.Dir(xmlpath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", dir)
+	}
+
+	err := os.WriteFile(xmlpath, body, 0644)
+	if err != nil {
+		return fmt.Errorf("error saving to file: %v", xmlpath)
+	}
+	return nil
+}
 
-	return fmt.Errorf("error downloading file: %v use GET", url)
+// wrap the error of a followed redirect so the domain is categorized as `CategoryRedirect`
+func followRedirect(url string, target string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RedirectError{URL: url, Target: target, Err: err}
 }
@@ -0,0 +1,124 @@
+package autodiscover
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const outlookResponseSchema = "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a"
+
+func readResponse(t *testing.T, name string) (*Autodiscover, []byte) {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ad Autodiscover
+	if err := xml.Unmarshal(body, &ad); err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+	return &ad, body
+}
+
+// unmarshal, marshal and unmarshal again, the model must not lose anything on the way
+func TestResponseRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.xml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no responses in testdata: %v", err)
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			ad, _ := readResponse(t, name)
+
+			marshaled, err := xml.Marshal(ad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var again Autodiscover
+			if err := xml.Unmarshal(marshaled, &again); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*ad, again) {
+				t.Errorf("round trip changed the response:\n%+v\n%+v", *ad, again)
+			}
+
+			if ad.Response.XMLNS != "" && !bytes.Contains(marshaled, []byte(`<Response xmlns="`+ad.Response.XMLNS+`">`)) {
+				t.Errorf("marshaled response lost its namespace: %s", marshaled)
+			}
+		})
+	}
+}
+
+func TestResponseExchangeSettings(t *testing.T) {
+	ad, _ := readResponse(t, "exchange2016_settings.xml")
+
+	if ad.XMLNS != "http://schemas.microsoft.com/exchange/autodiscover/responseschema/2006" || ad.Response.XMLNS != outlookResponseSchema {
+		t.Errorf("namespaces: %q, %q", ad.XMLNS, ad.Response.XMLNS)
+	}
+	if ad.Response.User == nil || ad.Response.User.AutoDiscoverSMTPAddress != "alice@contoso.com" || ad.Response.User.DeploymentId == "" {
+		t.Errorf("user: %+v", ad.Response.User)
+	}
+
+	account := ad.Response.Account
+	if len(account.AlternativeMailbox) != 2 || account.AlternativeMailbox[0].Type != "Archive" || account.AlternativeMailbox[1].Type != "Delegate" {
+		t.Errorf("alternative mailboxes: %+v", account.AlternativeMailbox)
+	}
+	if account.PublicFolderInformation == nil || account.PublicFolderInformation.SmtpAddress != "PublicFolderMailbox@contoso.com" {
+		t.Errorf("public folder information: %+v", account.PublicFolderInformation)
+	}
+
+	if len(account.Protocol) != 4 {
+		t.Fatalf("%v protocols", len(account.Protocol))
+	}
+	exch := account.Protocol[1]
+	if exch.Type != "EXCH" || exch.EcpUrlTmEditing == "" || exch.OABUrl == "" {
+		t.Errorf("EXCH protocol: %+v", exch)
+	}
+	web := account.Protocol[3]
+	if web.Internal == nil || len(web.Internal.OWAUrl) != 1 || web.Internal.OWAUrl[0].AuthenticationMethod != "Basic, Fba" || web.External.Protocol.Type != "EXPR" {
+		t.Errorf("WEB protocol: %+v", web)
+	}
+
+	m := ad.MapiHttp()
+	if m == nil || m.Version != "1" || m.AddressBookExternal == "" {
+		t.Errorf("mapiHttp: %+v", m)
+	}
+}
+
+func TestResponseMailSettings(t *testing.T) {
+	ad, _ := readResponse(t, "imap_pop_smtp.xml")
+
+	settings := ad.MailSettings("carol@example.net")
+	if len(settings.Incoming) != 2 || len(settings.Outgoing) != 1 {
+		t.Fatalf("settings: %+v", settings)
+	}
+	if s := settings.Incoming[0].String(); s != "imap imap.example.net:993 (tls)" {
+		t.Errorf("incoming: %v", s)
+	}
+	if s := settings.Outgoing[0].String(); s != "smtp smtp.example.net:587 (starttls)" {
+		t.Errorf("outgoing: %v", s)
+	}
+}
+
+func TestResponseRedirectsAndErrors(t *testing.T) {
+	ad, _ := readResponse(t, "redirect_addr.xml")
+	if ad.Response.Account.Action != "redirectAddr" || ad.Response.Account.RedirectAddr != "alice@contoso.mail.onmicrosoft.com" {
+		t.Errorf("redirectAddr: %+v", ad.Response.Account)
+	}
+
+	ad, _ = readResponse(t, "redirect_url.xml")
+	if ad.Response.Account.Action != "redirectUrl" || ad.Response.Account.RedirectUrl != "https://autodiscover-s.outlook.com/autodiscover/autodiscover.xml" {
+		t.Errorf("redirectUrl: %+v", ad.Response.Account)
+	}
+
+	ad, _ = readResponse(t, "error_600.xml")
+	if ad.Response.Account != nil || ad.Response.Error == nil || ad.Response.Error.ErrorCode != "600" || ad.Response.Error.Id != "2742418498" {
+		t.Errorf("error: %+v", ad.Response)
+	}
+}
@@ -0,0 +1,376 @@
+package autodiscover
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// one challenge of a WWW-Authenticate header, RFC 9110 11.6.1
+type Challenge struct {
+	Scheme string            // as sent, e.g. "Basic", "NTLM", "Negotiate", "Bearer"
+	Token  string            // token68, e.g. the NTLM CHALLENGE_MESSAGE in base64
+	Params map[string]string // auth-params with lower case names, e.g. "realm", "authorization_uri"
+}
+
+type Credentials struct {
+	Username    string // "user@example.com" or "DOMAIN\user"
+	Password    string
+	Domain      string // NTLM domain if Username doesn't carry one
+	BearerToken string // OAuth access token
+}
+
+// how an endpoint can be authenticated to, derived from its challenges
+type AuthClass string
+
+const (
+	AuthAnonymous AuthClass = "anonymous"     // answered without a 401
+	AuthBasicOnly AuthClass = "basic-only"    // only Basic
+	AuthWindows   AuthClass = "windows"       // NTLM and/or Negotiate, maybe with Basic
+	AuthModern    AuthClass = "modern-only"   // only Bearer
+	AuthHybrid    AuthClass = "modern+legacy" // Bearer together with Basic, NTLM or Negotiate
+	AuthUnknown   AuthClass = "unknown"       // 401 without any scheme listed above
+)
+
+// what a single endpoint answered, recorded by `Client`
+type EndpointResult struct {
+	URL           string
+	Method        string
+	StatusCode    int         // status of the last response, after authentication
+	Challenges    []Challenge // from the first 401 response
+	AuthClass     AuthClass
+	Authenticated string // scheme used to authenticate, "" if no credentials were sent
+	Refused       string // why the credentials were not sent although a scheme fits, e.g. over plain http
+	Fingerprint   Fingerprint
+}
+
+// a 401 the client could not (or was not allowed to) authenticate
+type AuthRequiredError struct {
+	Endpoint *EndpointResult
+}
+
+func (e *AuthRequiredError) Error() string {
+	if e.Endpoint.Authenticated != "" {
+		return fmt.Sprintf("authentication with %v failed at %v", e.Endpoint.Authenticated, e.Endpoint.URL)
+	}
+	if e.Endpoint.Refused != "" {
+		return fmt.Sprintf("authentication refused at %v: %v", e.Endpoint.URL, e.Endpoint.Refused)
+	}
+	return fmt.Sprintf("authentication required by %v, offered: %v", e.Endpoint.URL, strings.Join(e.Endpoint.Schemes(), ", "))
+}
+
+// the schemes offered by the endpoint, lower case and sorted
+func (r *EndpointResult) Schemes() []string {
+	seen := make(map[string]bool)
+	schemes := make([]string, 0, len(r.Challenges))
+	for _, c := range r.Challenges {
+		s := strings.ToLower(c.Scheme)
+		if !seen[s] {
+			seen[s] = true
+			schemes = append(schemes, s)
+		}
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// classify an endpoint by the challenges of its 401 response, no challenges means anonymous
+func Classify_Auth(challenges []Challenge) AuthClass {
+	if len(challenges) == 0 {
+		return AuthAnonymous
+	}
+
+	var basic, windows, bearer bool
+	for _, c := range challenges {
+		switch strings.ToLower(c.Scheme) {
+		case "basic":
+			basic = true
+		case "ntlm", "negotiate":
+			windows = true
+		case "bearer":
+			bearer = true
+		}
+	}
+
+	switch {
+	case bearer && (basic || windows):
+		return AuthHybrid
+	case bearer:
+		return AuthModern
+	case windows:
+		return AuthWindows
+	case basic:
+		return AuthBasicOnly
+	}
+	return AuthUnknown
+}
+
+// parse the values of all WWW-Authenticate headers of a response
+func Parse_WWWAuthenticate(headers []string) []Challenge {
+	challenges := make([]Challenge, 0)
+	for _, h := range headers {
+		challenges = append(challenges, parseChallenges(h)...)
+	}
+	return challenges
+}
+
+func parseChallenges(h string) []Challenge {
+	challenges := make([]Challenge, 0)
+	p := &headerParser{s: h}
+
+	for {
+		p.skip(" \t,")
+		if p.done() {
+			return challenges
+		}
+
+		scheme := p.token()
+		if scheme == "" {
+			// not a token, give up on the rest of the header
+			return challenges
+		}
+		c := Challenge{Scheme: scheme, Params: make(map[string]string)}
+		p.skip(" \t")
+
+		if p.startsParam() {
+			for {
+				name := p.token()
+				p.skip(" \t")
+				p.pos++ // "="
+				p.skip(" \t")
+				c.Params[strings.ToLower(name)] = p.value()
+				p.skip(" \t")
+
+				// another param of this challenge, or the next challenge
+				save := p.pos
+				p.skip(" \t,")
+				if p.done() || !p.startsParam() {
+					p.pos = save
+					break
+				}
+			}
+		} else {
+			c.Token = p.token68()
+		}
+
+		challenges = append(challenges, c)
+	}
+}
+
+type headerParser struct {
+	s   string
+	pos int
+}
+
+func (p *headerParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *headerParser) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func isTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune(`"(),/:;<=>?@[\]{}`, rune(c))
+}
+
+func (p *headerParser) token() string {
+	start := p.pos
+	for !p.done() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *headerParser) token68() string {
+	start := p.pos
+	for !p.done() && (isTokenChar(p.s[p.pos]) || p.s[p.pos] == '/') {
+		p.pos++
+	}
+	for !p.done() && p.s[p.pos] == '=' {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// whether the input at pos is `token BWS "=" BWS value`, and not token68 padding
+func (p *headerParser) startsParam() bool {
+	save := p.pos
+	defer func() { p.pos = save }()
+
+	if p.token() == "" {
+		return false
+	}
+	p.skip(" \t")
+	if p.done() || p.s[p.pos] != '=' {
+		return false
+	}
+	p.pos++
+	p.skip(" \t")
+	return !p.done() && p.s[p.pos] != '=' && p.s[p.pos] != ','
+}
+
+func (p *headerParser) value() string {
+	if p.done() || p.s[p.pos] != '"' {
+		return p.token()
+	}
+
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if !p.done() {
+				b.WriteByte(p.s[p.pos])
+				p.pos++
+			}
+		case '"':
+			return b.String()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// send the request built by newRequest, answering a 401 with the client's credentials.
+// newRequest is called once per attempt because request bodies can only be read once.
+func (c *Client) do(newRequest func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EndpointResult{URL: req.URL.String(), Method: req.Method}
+	c.Endpoints = append(c.Endpoints, result)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	result.StatusCode = resp.StatusCode
+	result.Fingerprint = Extract_Fingerprint(resp.Header)
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		result.AuthClass = AuthAnonymous
+		return resp, nil
+	}
+
+	result.Challenges = Parse_WWWAuthenticate(resp.Header.Values("WWW-Authenticate"))
+	result.AuthClass = Classify_Auth(result.Challenges)
+	if len(result.Challenges) == 0 {
+		result.AuthClass = AuthUnknown
+	}
+	if c.Credentials == nil {
+		return resp, nil
+	}
+
+	scheme := c.chooseScheme(result.Challenges)
+	if scheme == "" {
+		return resp, nil
+	}
+	// Basic and Bearer send the secret itself, NTLM a hash that can be cracked or relayed
+	if req.URL.Scheme != "https" {
+		result.Refused = fmt.Sprintf("%v challenge over %v, credentials are only sent over https", scheme, req.URL.Scheme)
+		return resp, nil
+	}
+	// keep the connection for the NTLM handshake
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	result.Authenticated = scheme
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		resp, err = c.sendWithAuthorization(newRequest, "Bearer "+c.Credentials.BearerToken)
+	case "basic":
+		token := base64.StdEncoding.EncodeToString([]byte(c.Credentials.Username + ":" + c.Credentials.Password))
+		resp, err = c.sendWithAuthorization(newRequest, "Basic "+token)
+	default: // NTLM, or NTLM wrapped in Negotiate
+		resp, err = c.ntlmHandshake(newRequest, scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.StatusCode = resp.StatusCode
+	result.Fingerprint.merge(Extract_Fingerprint(resp.Header))
+	return resp, nil
+}
+
+// pick the strongest offered scheme the credentials can answer
+func (c *Client) chooseScheme(challenges []Challenge) string {
+	offered := make(map[string]string)
+	for _, ch := range challenges {
+		offered[strings.ToLower(ch.Scheme)] = ch.Scheme
+	}
+
+	if c.Credentials.BearerToken != "" && offered["bearer"] != "" {
+		return offered["bearer"]
+	}
+	if c.Credentials.Username == "" {
+		return ""
+	}
+	for _, s := range []string{"ntlm", "negotiate", "basic"} {
+		if offered[s] != "" {
+			return offered[s]
+		}
+	}
+	return ""
+}
+
+func (c *Client) sendWithAuthorization(newRequest func() (*http.Request, error), authorization string) (*http.Response, error) {
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("refusing to send credentials to %v, not https", req.URL)
+	}
+	req.Header.Set("Authorization", authorization)
+	return c.HTTPClient.Do(req)
+}
+
+func (c *Client) ntlmHandshake(newRequest func() (*http.Request, error), scheme string) (*http.Response, error) {
+	resp, err := c.sendWithAuthorization(newRequest, scheme+" "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge, err := ntlmChallengeFrom(resp, scheme)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	domain, user := splitNTLMUser(c.Credentials.Username, c.Credentials.Domain)
+	msg, err := ntlmAuthenticateMessage(challenge, domain, user, c.Credentials.Password)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendWithAuthorization(newRequest, scheme+" "+base64.StdEncoding.EncodeToString(msg))
+}
+
+// the CHALLENGE_MESSAGE in the WWW-Authenticate header of a 401 response
+func ntlmChallengeFrom(resp *http.Response, scheme string) (*ntlmChallenge, error) {
+	for _, ch := range Parse_WWWAuthenticate(resp.Header.Values("WWW-Authenticate")) {
+		if !strings.EqualFold(ch.Scheme, scheme) || ch.Token == "" {
+			continue
+		}
+		msg, err := base64.StdEncoding.DecodeString(ch.Token)
+		if err != nil {
+			return nil, err
+		}
+		return parseNTLMChallenge(msg)
+	}
+	return nil, fmt.Errorf("no NTLM challenge in response")
+}
@@ -0,0 +1,130 @@
+package autodiscover
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// an autodiscover.<parent> host a client contacts when it walks up the domain tree ("back-off")
+type BackoffHost struct {
+	Host       string
+	Parent     string
+	ThirdParty bool     // the parent is above the registrable domain, so someone else owns the host
+	Addresses  []string // empty if the host doesn't resolve
+	Responds   bool     // the host answered a HTTP(S) request
+	URL        string   // the url that answered
+	StatusCode int
+}
+
+type BackoffResult struct {
+	EmailDomain       string
+	RegistrableDomain string
+	Hosts             []BackoffHost // nearest parent first
+	Exposed           bool          // a third party host resolves and responds, credentials of the domain's users can leak to it
+}
+
+type BackoffReport struct {
+	Domains         int
+	Exposed         int
+	ThirdPartyHosts map[string]int // responding third party host -> number of exposed domains
+}
+
+// enumerate the parent domain Autodiscover hosts a back-off client would contact for email_address
+// and check whether they resolve and respond. suffixlistpath is the file saved by `autoconfig.Get_PublicSuffixList`.
+func Analyze_Backoff(email_address string, suffixlistpath string) (*BackoffResult, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	tldMap, err := autoconfig.Load_PublicSuffixList(suffixlistpath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackoffResult{EmailDomain: addr.ASCIIDomain}
+	result.RegistrableDomain, err = autoconfig.Extract_SLDFromTLDmap(addr.ASCIIDomain, tldMap)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second, CheckRedirect: noRedirect}
+
+	for _, parent := range Get_BackoffParents(addr.ASCIIDomain) {
+		host := BackoffHost{
+			Host:       "autodiscover." + parent,
+			Parent:     parent,
+			ThirdParty: !isSubdomain(parent, result.RegistrableDomain),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		host.Addresses, _ = net.DefaultResolver.LookupHost(ctx, host.Host)
+		cancel()
+
+		if len(host.Addresses) > 0 {
+			for _, scheme := range []string{"https", "http"} {
+				url := utils.Build_URL(scheme, host.Host, autodiscoverPath, nil)
+				resp, err := client.Get(url)
+				if err != nil {
+					continue
+				}
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+				resp.Body.Close()
+
+				host.Responds = true
+				host.URL = url
+				host.StatusCode = resp.StatusCode
+				break
+			}
+		}
+
+		if host.ThirdParty && host.Responds {
+			result.Exposed = true
+		}
+		result.Hosts = append(result.Hosts, host)
+	}
+
+	return result, nil
+}
+
+// the proper parents of domain, nearest first, e.g. "a.example.co.uk" -> "example.co.uk", "co.uk", "uk"
+func Get_BackoffParents(domain string) []string {
+	labels := strings.Split(strings.Trim(strings.ToLower(domain), "."), ".")
+	parents := make([]string, 0, len(labels))
+	for i := 1; i < len(labels); i++ {
+		parents = append(parents, strings.Join(labels[i:], "."))
+	}
+	return parents
+}
+
+// aggregate the results of `Analyze_Backoff`
+func Summarize_Backoff(results []*BackoffResult) BackoffReport {
+	report := BackoffReport{ThirdPartyHosts: make(map[string]int)}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		report.Domains++
+		if !r.Exposed {
+			continue
+		}
+		report.Exposed++
+		for _, h := range r.Hosts {
+			if h.ThirdParty && h.Responds {
+				report.ThirdPartyHosts[h.Host]++
+			}
+		}
+	}
+	return report
+}
+
+func isSubdomain(domain string, parent string) bool {
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
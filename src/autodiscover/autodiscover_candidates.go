@@ -0,0 +1,110 @@
+package autodiscover
+
+import (
+	"net/http"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// one URL an Autodiscover client tries, in the order produced by `Get_AutodiscoverCandidates`
+type Candidate struct {
+	URL     string
+	Method  string           // "POST", or "GET" for the HTTP redirect method whose 302 Location is then POSTed to
+	Section string           // the MS-OXDISCO section (or client behavior) that produced the candidate
+	SRV     *utils.SRVTarget // the record behind a 3.1.5.3 candidate, nil for the others
+}
+
+// which client's discovery sequence to emulate
+type ClientBehavior string
+
+const (
+	// MS-OXDISCO 3.1.5 as written
+	BehaviorSpec ClientBehavior = "spec"
+	// Outlook 2016 and later, tries the Office 365 endpoint before the domain's own ("Direct Connect to Office 365")
+	BehaviorOutlook ClientBehavior = "outlook"
+	// the sequence this project used before, POSTs to the domain over plain HTTP first
+	BehaviorLegacy ClientBehavior = "legacy"
+)
+
+const (
+	autodiscoverPath = "/autodiscover/autodiscover.xml"
+	office365Host    = "autodiscover-s.outlook.com"
+)
+
+// generate the candidates for addr in the order of MS-OXDISCO 3.1.5, adjusted for behavior
+func Get_AutodiscoverCandidates(addr *utils.EmailAddress, behavior ClientBehavior) []Candidate {
+	email_domain := addr.ASCIIDomain
+	candidates := make([]Candidate, 0)
+
+	if behavior == BehaviorOutlook {
+		candidates = append(candidates, Candidate{
+			URL:     utils.Build_URL("https", office365Host, autodiscoverPath, nil),
+			Method:  http.MethodPost,
+			Section: "Outlook: Direct Connect to Office 365",
+		})
+	}
+
+	// MS-OXDISCO 3.1.5.1 SCP lookup in Active Directory is only possible inside the domain, not implemented
+
+	// MS-OXDISCO 3.1.5.2
+	if behavior == BehaviorLegacy {
+		candidates = append(candidates, Candidate{
+			URL:     utils.Build_URL("http", email_domain, autodiscoverPath, nil),
+			Method:  http.MethodPost,
+			Section: "legacy: plain HTTP POST to the domain",
+		})
+	} else {
+		candidates = append(candidates, Candidate{
+			URL:     utils.Build_URL("https", email_domain, autodiscoverPath, nil),
+			Method:  http.MethodPost,
+			Section: "MS-OXDISCO 3.1.5.2",
+		})
+	}
+	candidates = append(candidates, Candidate{
+		URL:     utils.Build_URL("https", "autodiscover."+email_domain, autodiscoverPath, nil),
+		Method:  http.MethodPost,
+		Section: "MS-OXDISCO 3.1.5.2",
+	})
+
+	// MS-OXDISCO 3.1.5.3
+	for _, target := range lookupAutodiscoverSRV(email_domain) {
+		if target.Class == utils.SRVUnavailable {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			URL:     utils.Build_URL("https", target.Target, autodiscoverPath, nil),
+			Method:  http.MethodPost,
+			Section: "MS-OXDISCO 3.1.5.3",
+			SRV:     &target,
+		})
+	}
+
+	// MS-OXDISCO 3.1.5.4
+	candidates = append(candidates, Candidate{
+		URL:     utils.Build_URL("http", "autodiscover."+email_domain, autodiscoverPath, nil),
+		Method:  http.MethodGet,
+		Section: "MS-OXDISCO 3.1.5.4",
+	})
+
+	return candidates
+}
+
+// _autodiscover._tcp targets ordered by priority, then weight
+func lookupAutodiscoverSRV(email_domain string) []utils.SRVTarget {
+	targets, _, err := utils.Lookup_SRVTargets("autodiscover", "tcp", email_domain)
+	if err != nil {
+		return nil
+	}
+	return targets
+}
+
+// the SRV records behind the 3.1.5.3 candidates, to aggregate with `utils.Summarize_SRVTargets`
+func Get_SRVTargets(candidates []Candidate) []utils.SRVTarget {
+	targets := make([]utils.SRVTarget, 0)
+	for _, c := range candidates {
+		if c.SRV != nil {
+			targets = append(targets, *c.SRV)
+		}
+	}
+	return targets
+}
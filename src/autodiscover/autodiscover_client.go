@@ -0,0 +1,69 @@
+package autodiscover
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// redirects followed before a discovery is given up, Outlook stops after 10
+const DefaultMaxRedirects = 10
+
+// an Autodiscover client, it records every endpoint it talks to
+type Client struct {
+	HTTPClient         *http.Client      // must not follow redirects, they are handled per MS-OXDSCLI 3.1.5.2
+	Credentials        *Credentials      // answer 401 challenges with these, nil to stay anonymous
+	MaxRedirects       int               // 0 means `DefaultMaxRedirects`
+	MapiHttpCapability int               // X-MapiHttpCapability sent with every POST, 0 to not advertise MAPI/HTTP
+	AnchorMailbox      bool              // send X-AnchorMailbox with the requested address, Exchange routes MAPI/HTTP requests by it
+	Endpoints          []*EndpointResult // every request sent, in order
+}
+
+func New_Client() *Client {
+	return &Client{
+		HTTPClient: &http.Client{
+			Timeout:       30 * time.Second,
+			CheckRedirect: noRedirect,
+		},
+	}
+}
+
+func Download_AutodiscoverXML(email_address string, path string) error {
+	return New_Client().Download_AutodiscoverXML(email_address, path, BehaviorSpec)
+}
+
+// like `Download_AutodiscoverXML`, but tries the candidates in the order the given client would
+func Download_AutodiscoverXMLAs(email_address string, path string, behavior ClientBehavior) error {
+	return New_Client().Download_AutodiscoverXML(email_address, path, behavior)
+}
+
+// download the settings of every address like `Download_AutodiscoverXML`, rejected false positives are counted apart
+func Download_AutodiscoverXMLs(email_addresses []string, path string) utils.ResponseStats {
+	c := New_Client()
+	var stats utils.ResponseStats
+	for _, email_address := range email_addresses {
+		stats.Record(c.Download_AutodiscoverXML(email_address, path, BehaviorSpec))
+	}
+	return stats
+}
+
+func Discover(email_address string, behavior ClientBehavior) (*Discovery, error) {
+	return New_Client().Discover(email_address, behavior)
+}
+
+func Discover_Candidates(email_address string, candidates []Candidate) (*Discovery, error) {
+	return New_Client().Discover_Candidates(email_address, candidates)
+}
+
+func Post_Autodiscoverxml(url string, xmlpath string, email_address string) error {
+	return New_Client().Post_Autodiscoverxml(url, xmlpath, email_address)
+}
+
+func Get_AutodiscoverXML(url string, xmlpath string, email_address string) error {
+	return New_Client().Get_AutodiscoverXML(url, xmlpath, email_address)
+}
+
+func noRedirect(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
@@ -0,0 +1,232 @@
+package autodiscover
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// |t| of Welch's t-test above which the response times of the two addresses are taken as different
+const EnumerationTimingThreshold = 4.0
+
+// rounds used by `Probe_UserEnumeration` when rounds is 0
+const DefaultEnumerationRounds = 10
+
+// one Autodiscover request sent while probing for user enumeration
+type EnumerationSample struct {
+	StatusCode int
+	ErrorCode  string // of an <Error> response
+	Action     string // of an <Account> response
+	BodyLength int    // with the requested address removed, so addresses of different length compare
+	Duration   time.Duration
+	Err        string // transport error, the other fields are empty
+}
+
+// whether an endpoint answers an existing mailbox differently from a nonexistent one
+type EnumerationResult struct {
+	URL              string
+	KnownAddress     string // supplied by the caller, expected to exist
+	RandomAddress    string // random local part in the same domain, expected not to exist
+	Known            []EnumerationSample
+	Random           []EnumerationSample
+	StatusDiffers    bool
+	ErrorCodeDiffers bool
+	ActionDiffers    bool
+	LengthDiffers    bool    // the body lengths of the two addresses don't overlap
+	KnownMean        float64 // mean response time in milliseconds
+	RandomMean       float64
+	TimingT          float64 // Welch's t statistic of the response times
+	TimingDiffers    bool
+	Leaks            bool // any of the above, the endpoint reveals whether a mailbox exists
+	Inconclusive     bool // one of the addresses got no answer at all, nothing was compared
+}
+
+// probe url with known_address and a random address of the same domain, rounds requests each, alternating.
+// this is opt-in: it sends an address the caller believes to exist and should only be run against endpoints
+// the caller is allowed to test.
+func (c *Client) Probe_UserEnumeration(url string, known_address string, rounds int) (*EnumerationResult, error) {
+	addr, err := utils.Parse_EmailAddress(known_address)
+	if err != nil {
+		return nil, err
+	}
+	if rounds <= 0 {
+		rounds = DefaultEnumerationRounds
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	result := &EnumerationResult{
+		URL:           url,
+		KnownAddress:  addr.ASCII(),
+		RandomAddress: hex.EncodeToString(random) + "@" + addr.ASCIIDomain,
+	}
+
+	for i := 0; i < rounds; i++ {
+		result.Known = append(result.Known, c.enumerationSample(url, result.KnownAddress))
+		result.Random = append(result.Random, c.enumerationSample(url, result.RandomAddress))
+	}
+
+	// a side without answers would differ in everything from the other
+	if answered(result.Known) == 0 || answered(result.Random) == 0 {
+		result.Inconclusive = true
+		return result, nil
+	}
+
+	result.StatusDiffers = mostCommon(result.Known, func(s EnumerationSample) string { return strconv.Itoa(s.StatusCode) }) !=
+		mostCommon(result.Random, func(s EnumerationSample) string { return strconv.Itoa(s.StatusCode) })
+	result.ErrorCodeDiffers = mostCommon(result.Known, func(s EnumerationSample) string { return s.ErrorCode }) !=
+		mostCommon(result.Random, func(s EnumerationSample) string { return s.ErrorCode })
+	result.ActionDiffers = mostCommon(result.Known, func(s EnumerationSample) string { return s.Action }) !=
+		mostCommon(result.Random, func(s EnumerationSample) string { return s.Action })
+
+	knownMin, knownMax := lengthRange(result.Known)
+	randomMin, randomMax := lengthRange(result.Random)
+	result.LengthDiffers = knownMax < randomMin || randomMax < knownMin
+
+	knownMs, randomMs := durations(result.Known), durations(result.Random)
+	var knownVar, randomVar float64
+	result.KnownMean, knownVar = meanVariance(knownMs)
+	result.RandomMean, randomVar = meanVariance(randomMs)
+	result.TimingT = welchT(result.KnownMean, knownVar, len(knownMs), result.RandomMean, randomVar, len(randomMs))
+	result.TimingDiffers = math.Abs(result.TimingT) > EnumerationTimingThreshold
+
+	result.Leaks = result.StatusDiffers || result.ErrorCodeDiffers || result.ActionDiffers || result.LengthDiffers || result.TimingDiffers
+	return result, nil
+}
+
+func Probe_UserEnumeration(url string, known_address string, rounds int) (*EnumerationResult, error) {
+	return New_Client().Probe_UserEnumeration(url, known_address, rounds)
+}
+
+// send one request without following redirects or classifying the answer
+func (c *Client) enumerationSample(url string, email_address string) EnumerationSample {
+	sample := EnumerationSample{}
+
+	requestbyte, err := autodiscoverRequest(email_address)
+	if err != nil {
+		sample.Err = err.Error()
+		return sample
+	}
+
+	start := time.Now()
+	resp, err := c.postRequest(url, email_address, requestbyte)
+	if err != nil {
+		sample.Err = err.Error()
+		return sample
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	sample.Duration = time.Since(start)
+	sample.StatusCode = resp.StatusCode
+	if err != nil {
+		sample.Err = err.Error()
+		return sample
+	}
+
+	sample.BodyLength = len(bytes.ReplaceAll(body, []byte(email_address), nil))
+
+	var AD Autodiscover
+	if xml.Unmarshal(body, &AD) == nil && AD.Response != nil {
+		if AD.Response.Error != nil {
+			sample.ErrorCode = AD.Response.Error.ErrorCode
+		}
+		if AD.Response.Account != nil {
+			sample.Action = AD.Response.Account.Action
+		}
+	}
+	return sample
+}
+
+// the samples without a transport error
+func answered(samples []EnumerationSample) int {
+	n := 0
+	for _, s := range samples {
+		if s.Err == "" {
+			n++
+		}
+	}
+	return n
+}
+
+func mostCommon(samples []EnumerationSample, key func(EnumerationSample) string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, s := range samples {
+		if s.Err != "" {
+			continue
+		}
+		k := key(s)
+		counts[k]++
+		if counts[k] > counts[best] || (counts[k] == counts[best] && k < best) {
+			best = k
+		}
+	}
+	return best
+}
+
+func lengthRange(samples []EnumerationSample) (int, int) {
+	lo, hi := math.MaxInt, -1
+	for _, s := range samples {
+		if s.Err != "" {
+			continue
+		}
+		lo = min(lo, s.BodyLength)
+		hi = max(hi, s.BodyLength)
+	}
+	if hi < 0 {
+		// no answers, nothing to compare
+		return 0, math.MaxInt
+	}
+	return lo, hi
+}
+
+func durations(samples []EnumerationSample) []float64 {
+	ms := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if s.Err == "" {
+			ms = append(ms, float64(s.Duration)/float64(time.Millisecond))
+		}
+	}
+	return ms
+}
+
+// mean and sample variance
+func meanVariance(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	sq := 0.0
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, sq / float64(len(values)-1)
+}
+
+func welchT(mean1 float64, var1 float64, n1 int, mean2 float64, var2 float64, n2 int) float64 {
+	if n1 < 2 || n2 < 2 {
+		return 0
+	}
+	se := math.Sqrt(var1/float64(n1) + var2/float64(n2))
+	if se == 0 {
+		return 0
+	}
+	return (mean1 - mean2) / se
+}
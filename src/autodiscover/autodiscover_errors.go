@@ -0,0 +1,113 @@
+package autodiscover
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// MS-OXDSCLI 2.2.4.2.1.2 ErrorCode values
+var ErrorCodes = map[string]string{
+	"500": "The email address can't be found.",
+	"501": "The requested settings are not available for the account.",
+	"600": "Invalid request.",
+	"601": "The requested AcceptableResponseSchema is not supported.",
+	"602": "The account is in a state that doesn't allow Autodiscover.",
+	"603": "The server encountered an internal error while processing the request.",
+}
+
+// the meaning of an ErrorCode, "unknown error code" if it is not in `ErrorCodes`
+func ErrorCode_Meaning(code string) string {
+	meaning, ok := ErrorCodes[strings.TrimSpace(code)]
+	if !ok {
+		return "unknown error code"
+	}
+	return meaning
+}
+
+// an Autodiscover response that contains an <Error> element
+type ResponseError struct {
+	URL    string
+	Detail Error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("autodiscover error %v from %v: %v (%v)", e.Detail.ErrorCode, e.URL, ErrorCode_Meaning(e.Detail.ErrorCode), e.Detail.Message)
+}
+
+// a redirect (301, 302, 307, 308, redirectAddr or redirectUrl) that could not be followed to settings
+type RedirectError struct {
+	URL    string
+	Target string // the address or url redirected to
+	Err    error
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect from %v to %v failed: %v", e.URL, e.Target, e.Err)
+}
+
+func (e *RedirectError) Unwrap() error {
+	return e.Err
+}
+
+type ResultCategory string
+
+const (
+	CategorySettings ResultCategory = "settings" // a response with Action "settings"
+	CategoryRedirect ResultCategory = "redirect" // a redirect was returned but didn't lead to settings
+	CategoryError    ResultCategory = "error"    // the server answered with an <Error> response
+	CategoryInvalid  ResultCategory = "invalid"  // HTTP 200 that is not a usable Autodiscover response
+	CategoryNone     ResultCategory = "none"     // no Autodiscover server answered
+)
+
+// categorize a single response body
+func Categorize_Response(body []byte) (ResultCategory, *Autodiscover) {
+	class := utils.Classify_Response("", body, "Autodiscover")
+	if class.Class != utils.ResponseValid {
+		return CategoryInvalid, nil
+	}
+
+	var AD Autodiscover
+	if err := xml.Unmarshal(body, &AD); err != nil {
+		return CategoryInvalid, nil
+	}
+
+	switch {
+	case AD.Response == nil:
+		return CategoryInvalid, &AD
+	case AD.Response.Error != nil:
+		return CategoryError, &AD
+	case AD.Response.Account == nil:
+		return CategoryInvalid, &AD
+	case AD.Response.Account.Action == "redirectAddr" || AD.Response.Account.Action == "redirectUrl":
+		return CategoryRedirect, &AD
+	case AD.Response.Account.Action == "settings":
+		return CategorySettings, &AD
+	}
+	return CategoryInvalid, &AD
+}
+
+// categorize a domain by the error `Download_AutodiscoverXML` returned for it,
+// the most useful answer any candidate gave wins
+func Categorize_Result(err error) ResultCategory {
+	if err == nil {
+		return CategorySettings
+	}
+
+	// errors.As searches every attempt of a `*utils.NotFoundError`
+	var redirect *RedirectError
+	var response *ResponseError
+	var rejected *utils.RejectedResponseError
+	switch {
+	case errors.As(err, &redirect):
+		return CategoryRedirect
+	case errors.As(err, &response):
+		return CategoryError
+	case errors.As(err, &rejected):
+		return CategoryInvalid
+	}
+	return CategoryNone
+}
@@ -0,0 +1,209 @@
+package autodiscover
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// what the response headers of an endpoint reveal about the software behind it
+type Fingerprint struct {
+	Product       string   // e.g. "Exchange 2019", "Zimbra", "Kerio Connect"
+	Version       string   // e.g. "15.2.1118.7"
+	Provider      string   // see the Provider constants
+	Server        string   // Server header
+	PoweredBy     string   // X-Powered-By header
+	InternalNames []string // back end and front end host names leaked by Exchange, sorted
+}
+
+const (
+	ProviderExchangeOnline = "Exchange Online"
+	ProviderExchangeOnPrem = "on-premises Exchange"
+	ProviderZimbra         = "Zimbra"
+	ProviderKerio          = "Kerio"
+	ProviderOther          = "other"
+	ProviderUnknown        = "unknown"
+)
+
+// headers Exchange uses to name the servers that handled the request
+var internalNameHeaders = []string{
+	"X-FEServer",
+	"X-BEServer",
+	"X-CalculatedBETarget",
+	"X-CalculatedFETarget",
+	"X-TargetBEServer",
+	"X-DiagInfo",
+}
+
+// Exchange major.minor in X-OWA-Version
+var exchangeVersions = map[string]string{
+	"8.":    "Exchange 2007",
+	"14.":   "Exchange 2010",
+	"15.0.": "Exchange 2013",
+	"15.1.": "Exchange 2016",
+	"15.2.": "Exchange 2019",
+}
+
+// Server header prefixes of other mail servers with an Autodiscover implementation
+var serverProducts = []struct {
+	Prefix   string
+	Product  string
+	Provider string
+}{
+	{"Kerio Connect", "Kerio Connect", ProviderKerio},
+	{"Zimbra", "Zimbra", ProviderZimbra},
+	{"IceWarp", "IceWarp", ProviderOther},
+	{"MDaemon", "MDaemon", ProviderOther},
+	{"SmarterMail", "SmarterMail", ProviderOther},
+	{"Axigen", "Axigen", ProviderOther},
+	{"Open-Xchange", "Open-Xchange", ProviderOther},
+}
+
+// Exchange Online server names look like "AM6PR04CA0012" or "BN6PR1101MB2145"
+var exchangeOnlineName = regexp.MustCompile(`(?i)^[A-Z]{2,3}\d[A-Z]{0,2}PR\d{2,}`)
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// fingerprint an endpoint from the headers of its response
+func Extract_Fingerprint(header http.Header) Fingerprint {
+	fp := Fingerprint{
+		Server:    header.Get("Server"),
+		PoweredBy: header.Get("X-Powered-By"),
+		Provider:  ProviderUnknown,
+	}
+
+	names := make(map[string]bool)
+	for _, h := range internalNameHeaders {
+		for _, value := range header.Values(h) {
+			for _, name := range hostNames(value) {
+				names[name] = true
+			}
+		}
+	}
+	for name := range names {
+		fp.InternalNames = append(fp.InternalNames, name)
+	}
+	sort.Strings(fp.InternalNames)
+
+	owaVersion := strings.TrimSpace(header.Get("X-OWA-Version"))
+	exchange := owaVersion != "" || len(fp.InternalNames) > 0 || header.Get("X-FEProxyInfo") != ""
+	if exchange {
+		fp.Product = "Exchange"
+		fp.Version = owaVersion
+		for prefix, product := range exchangeVersions {
+			if strings.HasPrefix(owaVersion, prefix) {
+				fp.Product = product
+			}
+		}
+
+		fp.Provider = ProviderExchangeOnPrem
+		if isExchangeOnline(header, fp.InternalNames) {
+			fp.Provider = ProviderExchangeOnline
+		}
+		return fp
+	}
+
+	for _, p := range serverProducts {
+		if strings.HasPrefix(strings.ToLower(fp.Server), strings.ToLower(p.Prefix)) {
+			fp.Product = p.Product
+			fp.Version = versionPattern.FindString(fp.Server)
+			fp.Provider = p.Provider
+			return fp
+		}
+	}
+
+	// Zimbra sits behind nginx, its cookies give it away
+	for _, cookie := range header.Values("Set-Cookie") {
+		if strings.HasPrefix(cookie, "ZM_") {
+			fp.Product = "Zimbra"
+			fp.Provider = ProviderZimbra
+			return fp
+		}
+	}
+
+	if fp.Server != "" || fp.PoweredBy != "" {
+		fp.Provider = ProviderOther
+	}
+	return fp
+}
+
+func isExchangeOnline(header http.Header, names []string) bool {
+	for _, h := range append(internalNameHeaders, "X-FEProxyInfo") {
+		if strings.Contains(strings.ToLower(header.Get(h)), "outlook.com") {
+			return true
+		}
+	}
+	for _, name := range names {
+		if exchangeOnlineName.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// host names in a header value like "EXCH01" or "exch01.corp.example.com:444"
+func hostNames(value string) []string {
+	names := make([]string, 0)
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	}) {
+		if i := strings.Index(field, ":"); i >= 0 {
+			field = field[:i]
+		}
+		field = strings.Trim(field, ".")
+		if isHostName(field) {
+			names = append(names, strings.ToLower(field))
+		}
+	}
+	return names
+}
+
+func isHostName(s string) bool {
+	if len(s) > 253 {
+		return false
+	}
+	letter := false
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+			letter = true
+		case '0' <= r && r <= '9', r == '-', r == '.':
+		default:
+			return false
+		}
+	}
+	return letter
+}
+
+// combine the fingerprints of several responses of the same endpoint
+func (fp *Fingerprint) merge(other Fingerprint) {
+	rank := func(provider string) int {
+		switch provider {
+		case "", ProviderUnknown:
+			return 0
+		case ProviderOther:
+			return 1
+		}
+		return 2
+	}
+	if rank(other.Provider) > rank(fp.Provider) {
+		fp.Product, fp.Version, fp.Provider = other.Product, other.Version, other.Provider
+	}
+	if fp.Server == "" {
+		fp.Server = other.Server
+	}
+	if fp.PoweredBy == "" {
+		fp.PoweredBy = other.PoweredBy
+	}
+
+	names := make(map[string]bool)
+	for _, n := range append(fp.InternalNames, other.InternalNames...) {
+		names[n] = true
+	}
+	fp.InternalNames = fp.InternalNames[:0]
+	for n := range names {
+		fp.InternalNames = append(fp.InternalNames, n)
+	}
+	sort.Strings(fp.InternalNames)
+}
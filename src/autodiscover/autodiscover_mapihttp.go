@@ -0,0 +1,115 @@
+package autodiscover
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// the mailstore or address book endpoint of a MAPI/HTTP protocol element, MS-OXDSCLI 2.2.4.1.1.2.4
+type MapiHttpEndpoint struct {
+	InternalUrl string `xml:"InternalUrl,omitempty"`
+	ExternalUrl string `xml:"ExternalUrl,omitempty"`
+}
+
+// the URLs a MAPI/HTTP client connects to, taken from the `Protocol` with Type "mapiHttp"
+type MapiHttpSettings struct {
+	Version             string
+	MailStoreInternal   string
+	MailStoreExternal   string
+	AddressBookInternal string
+	AddressBookExternal string
+}
+
+// the same discovery with and without X-MapiHttpCapability
+type MapiHttpComparison struct {
+	EmailAddress string
+	Without      *Discovery
+	With         *Discovery
+	ErrWithout   error
+	ErrWith      error
+	MapiHttp     *MapiHttpSettings // nil if the response to the capable request has no mapiHttp protocol
+	Differs      bool              // the two responses differ beyond the mapiHttp protocol, e.g. a different endpoint or outcome
+}
+
+// MS-OXDSCLI 2.2.2.1, the headers of a client that can speak MAPI/HTTP
+func (c *Client) setMapiHttpHeaders(req *http.Request, email_address string) {
+	if c.MapiHttpCapability > 0 {
+		req.Header.Set("X-MapiHttpCapability", strconv.Itoa(c.MapiHttpCapability))
+	}
+	if c.AnchorMailbox {
+		req.Header.Set("X-AnchorMailbox", email_address)
+	}
+}
+
+// the mapiHttp protocol of the response, nil if there is none
+func (ad *Autodiscover) MapiHttp() *MapiHttpSettings {
+	if ad.Response == nil || ad.Response.Account == nil {
+		return nil
+	}
+	for _, p := range ad.Response.Account.Protocol {
+		if p.TypeAttribute != "mapiHttp" {
+			continue
+		}
+		settings := &MapiHttpSettings{Version: p.Version}
+		if p.MailStore != nil {
+			settings.MailStoreInternal = p.MailStore.InternalUrl
+			settings.MailStoreExternal = p.MailStore.ExternalUrl
+		}
+		if p.AddressBook != nil {
+			settings.AddressBookInternal = p.AddressBook.InternalUrl
+			settings.AddressBookExternal = p.AddressBook.ExternalUrl
+		}
+		return settings
+	}
+	return nil
+}
+
+// discover the settings of email_address once without and once with MAPI/HTTP advertised (version 1 and X-AnchorMailbox).
+// both discoveries use the credentials of c, the endpoints of both are recorded in c.
+func (c *Client) Compare_MapiHttp(email_address string, behavior ClientBehavior) *MapiHttpComparison {
+	result := &MapiHttpComparison{EmailAddress: email_address}
+
+	plain := *c
+	plain.MapiHttpCapability = 0
+	plain.AnchorMailbox = false
+	result.Without, result.ErrWithout = plain.Discover(email_address, behavior)
+
+	capable := *c
+	capable.MapiHttpCapability = 1
+	capable.AnchorMailbox = true
+	capable.Endpoints = plain.Endpoints
+	result.With, result.ErrWith = capable.Discover(email_address, behavior)
+	c.Endpoints = capable.Endpoints
+
+	if result.With != nil && result.With.Autodiscover != nil {
+		result.MapiHttp = result.With.Autodiscover.MapiHttp()
+	}
+
+	switch {
+	case (result.Without == nil) != (result.With == nil):
+		result.Differs = true
+	case result.Without != nil:
+		result.Differs = result.Without.URL != result.With.URL ||
+			result.Without.EmailAddress != result.With.EmailAddress ||
+			protocolCount(result.Without)+boolInt(result.MapiHttp != nil) != protocolCount(result.With)
+	}
+	return result
+}
+
+func Compare_MapiHttp(email_address string, behavior ClientBehavior) *MapiHttpComparison {
+	return New_Client().Compare_MapiHttp(email_address, behavior)
+}
+
+func protocolCount(d *Discovery) int {
+	if d.Autodiscover == nil || d.Autodiscover.Response == nil || d.Autodiscover.Response.Account == nil {
+		return 0
+	}
+	return len(d.Autodiscover.Response.Account.Protocol)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
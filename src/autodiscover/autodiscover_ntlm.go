@@ -0,0 +1,197 @@
+package autodiscover
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// MS-NLMP 2.2.2.5 NEGOTIATE flags
+const (
+	ntlmNegotiateUnicode                 = 0x00000001
+	ntlmNegotiateOEM                     = 0x00000002
+	ntlmRequestTarget                    = 0x00000004
+	ntlmNegotiateNTLM                    = 0x00000200
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo              = 0x00800000
+	ntlmNegotiateVersion                 = 0x02000000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiate56                      = 0x80000000
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// MS-NLMP 2.2.1.2 CHALLENGE_MESSAGE
+type ntlmChallenge struct {
+	Flags      uint32
+	TargetName string
+	Challenge  [8]byte
+	TargetInfo []byte // AV_PAIR list, MS-NLMP 2.2.2.1
+	Version    []byte // 8 bytes, only if NEGOTIATE_VERSION is set
+}
+
+// MS-NLMP 2.2.1.1 NEGOTIATE_MESSAGE without domain and workstation.
+// NEGOTIATE_VERSION makes servers include their OS version in the challenge.
+func ntlmNegotiateMessage() []byte {
+	flags := uint32(ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSessionSecurity | ntlmNegotiateTargetInfo |
+		ntlmNegotiateVersion | ntlmNegotiate128 | ntlmNegotiate56)
+
+	msg := make([]byte, 40)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], flags)
+	// DomainNameFields and WorkstationFields stay empty, the payload would start at 40
+	binary.LittleEndian.PutUint32(msg[20:], 40)
+	binary.LittleEndian.PutUint32(msg[28:], 40)
+	// VERSION 2.2.2.10: 10.0, build 0, NTLMSSP_REVISION_W2K3
+	msg[32] = 10
+	msg[33] = 0
+	msg[39] = 0x0f
+	return msg
+}
+
+func parseNTLMChallenge(msg []byte) (*ntlmChallenge, error) {
+	if len(msg) < 32 || !bytes.Equal(msg[:8], ntlmSignature) {
+		return nil, fmt.Errorf("not an NTLMSSP message")
+	}
+	if binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return nil, fmt.Errorf("not an NTLM CHALLENGE_MESSAGE")
+	}
+
+	c := &ntlmChallenge{Flags: binary.LittleEndian.Uint32(msg[20:])}
+	copy(c.Challenge[:], msg[24:32])
+
+	targetName, err := ntlmField(msg, 12)
+	if err != nil {
+		return nil, err
+	}
+	if c.Flags&ntlmNegotiateUnicode != 0 {
+		c.TargetName = decodeUTF16(targetName)
+	} else {
+		c.TargetName = string(targetName)
+	}
+
+	if len(msg) >= 48 {
+		c.TargetInfo, err = ntlmField(msg, 40)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(msg) >= 56 && c.Flags&ntlmNegotiateVersion != 0 {
+		c.Version = msg[48:56]
+	}
+
+	return c, nil
+}
+
+// read a Len/MaxLen/Offset field that starts at pos
+func ntlmField(msg []byte, pos int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(msg[pos:]))
+	offset := int(binary.LittleEndian.Uint32(msg[pos+4:]))
+	if length == 0 {
+		return nil, nil
+	}
+	if offset < 0 || offset+length > len(msg) {
+		return nil, fmt.Errorf("NTLM field out of range")
+	}
+	return msg[offset : offset+length], nil
+}
+
+// MS-NLMP 2.2.1.3 AUTHENTICATE_MESSAGE with an NTLMv2 response (3.3.2)
+func ntlmAuthenticateMessage(challenge *ntlmChallenge, domain string, user string, password string) ([]byte, error) {
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+
+	timestamp := uint64(time.Now().UnixNano()/100) + 116444736000000000 // FILETIME
+	ntowf := ntowfV2(domain, user, password)
+	ntResponse, lmResponse := ntlmV2Responses(ntowf, challenge.Challenge[:], clientChallenge, timestamp, challenge.TargetInfo)
+
+	flags := uint32(ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSessionSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56)
+
+	payloads := [][]byte{lmResponse, ntResponse, encodeUTF16(domain), encodeUTF16(user), nil, nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+
+	offset := len(msg)
+	for i, payload := range payloads {
+		pos := 12 + i*8
+		binary.LittleEndian.PutUint16(msg[pos:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(msg[pos+2:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(msg[pos+4:], uint32(offset))
+		offset += len(payload)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+
+	for _, payload := range payloads {
+		msg = append(msg, payload...)
+	}
+	return msg, nil
+}
+
+// MS-NLMP 3.3.2 NTOWFv2
+func ntowfV2(domain string, user string, password string) []byte {
+	hash := md4.New()
+	hash.Write(encodeUTF16(password))
+	return hmacMD5(hash.Sum(nil), encodeUTF16(strings.ToUpper(user)+domain))
+}
+
+// MS-NLMP 3.3.2 NtChallengeResponse and LmChallengeResponse
+func ntlmV2Responses(ntowf []byte, serverChallenge []byte, clientChallenge []byte, timestamp uint64, targetInfo []byte) ([]byte, []byte) {
+	temp := make([]byte, 0, 28+len(targetInfo)+4)
+	temp = append(temp, 0x01, 0x01, 0, 0, 0, 0, 0, 0)
+	temp = binary.LittleEndian.AppendUint64(temp, timestamp)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntProof := hmacMD5(ntowf, append(append([]byte{}, serverChallenge...), temp...))
+	ntResponse := append(ntProof, temp...)
+	lmResponse := append(hmacMD5(ntowf, append(append([]byte{}, serverChallenge...), clientChallenge...)), clientChallenge...)
+	return ntResponse, lmResponse
+}
+
+// split "DOMAIN\user" and "user@domain", NTLM wants the domain separately
+func splitNTLMUser(username string, domain string) (string, string) {
+	if d, u, ok := strings.Cut(username, `\`); ok {
+		return d, u
+	}
+	return domain, username
+}
+
+func hmacMD5(key []byte, data []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func encodeUTF16(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
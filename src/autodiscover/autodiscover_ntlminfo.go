@@ -0,0 +1,180 @@
+package autodiscover
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// what an anonymous NTLM negotiate discloses about the server, MS-NLMP 2.2.1.2
+type NTLMInfo struct {
+	URL             string
+	Scheme          string // "NTLM" or "Negotiate"
+	TargetName      string
+	NetBIOSDomain   string // MsvAvNbDomainName
+	NetBIOSComputer string // MsvAvNbComputerName
+	DNSDomain       string // MsvAvDnsDomainName
+	DNSComputer     string // MsvAvDnsComputerName
+	DNSTree         string // MsvAvDnsTreeName
+	OSVersion       string // "major.minor.build" from the VERSION structure, "" if not sent
+	Timestamp       time.Time
+}
+
+// MS-NLMP 2.2.2.1 AvId values
+const (
+	msvAvEOL             = 0
+	msvAvNbComputerName  = 1
+	msvAvNbDomainName    = 2
+	msvAvDnsComputerName = 3
+	msvAvDnsDomainName   = 4
+	msvAvDnsTreeName     = 5
+	msvAvTimestamp       = 7
+)
+
+// send an NTLM NEGOTIATE_MESSAGE to url and decode the CHALLENGE_MESSAGE, no credentials are used.
+// This is an active probe of the server, only run it where that is allowed.
+func (c *Client) Probe_NTLMInfo(url string) (*NTLMInfo, error) {
+	// sent with the HTTP client itself, `Client.do` would answer the challenge with the client's credentials
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// which of NTLM and Negotiate the endpoint offers
+	result := &EndpointResult{URL: url, Method: req.Method, StatusCode: resp.StatusCode, Fingerprint: Extract_Fingerprint(resp.Header)}
+	result.Challenges = Parse_WWWAuthenticate(resp.Header.Values("WWW-Authenticate"))
+	result.AuthClass = Classify_Auth(result.Challenges)
+	c.Endpoints = append(c.Endpoints, result)
+
+	scheme := ""
+	for _, ch := range result.Challenges {
+		if strings.EqualFold(ch.Scheme, "NTLM") {
+			scheme = ch.Scheme
+			break
+		}
+		if strings.EqualFold(ch.Scheme, "Negotiate") {
+			scheme = ch.Scheme
+		}
+	}
+	if scheme == "" {
+		return nil, fmt.Errorf("%v doesn't offer NTLM or Negotiate", url)
+	}
+
+	// the NEGOTIATE_MESSAGE names no user, it is safe over http too
+	req, err = http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", scheme+" "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	resp, err = c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	challenge, err := ntlmChallengeFrom(resp, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", url, err)
+	}
+
+	info := &NTLMInfo{URL: url, Scheme: scheme, TargetName: challenge.TargetName}
+	if len(challenge.Version) == 8 {
+		info.OSVersion = fmt.Sprintf("%d.%d.%d", challenge.Version[0], challenge.Version[1], binary.LittleEndian.Uint16(challenge.Version[2:]))
+	}
+	parseAvPairs(challenge.TargetInfo, info)
+
+	return info, nil
+}
+
+func Probe_NTLMInfo(url string) (*NTLMInfo, error) {
+	return New_Client().Probe_NTLMInfo(url)
+}
+
+// probe every POST candidate of the domain, endpoints that don't offer NTLM are skipped
+func Probe_DomainNTLMInfo(email_address string, behavior ClientBehavior) ([]*NTLMInfo, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := New_Client()
+	infos := make([]*NTLMInfo, 0)
+	for _, candidate := range Get_AutodiscoverCandidates(addr, behavior) {
+		if candidate.Method != http.MethodPost {
+			continue
+		}
+		info, err := c.Probe_NTLMInfo(candidate.URL)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// save the results of `Probe_DomainNTLMInfo` to a file in format of json
+func Save_NTLMInfo(infos []*NTLMInfo, jsonpath string) error {
+	dir := filepath.Dir(jsonpath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", dir)
+	}
+
+	file, err := os.Create(jsonpath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %s", jsonpath)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(infos); err != nil {
+		return fmt.Errorf("failed to write to file: %s", jsonpath)
+	}
+	return nil
+}
+
+// MS-NLMP 2.2.2.1 AV_PAIR list
+func parseAvPairs(data []byte, info *NTLMInfo) {
+	for len(data) >= 4 {
+		id := binary.LittleEndian.Uint16(data)
+		length := int(binary.LittleEndian.Uint16(data[2:]))
+		if id == msvAvEOL || 4+length > len(data) {
+			return
+		}
+		value := data[4 : 4+length]
+
+		switch id {
+		case msvAvNbComputerName:
+			info.NetBIOSComputer = decodeUTF16(value)
+		case msvAvNbDomainName:
+			info.NetBIOSDomain = decodeUTF16(value)
+		case msvAvDnsComputerName:
+			info.DNSComputer = decodeUTF16(value)
+		case msvAvDnsDomainName:
+			info.DNSDomain = decodeUTF16(value)
+		case msvAvDnsTreeName:
+			info.DNSTree = decodeUTF16(value)
+		case msvAvTimestamp:
+			if length == 8 {
+				filetime := binary.LittleEndian.Uint64(value)
+				info.Timestamp = time.Unix(0, int64(filetime-116444736000000000)*100).UTC()
+			}
+		}
+		data = data[4+length:]
+	}
+}
@@ -0,0 +1,136 @@
+package autodiscover
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func utf16le(s string) []byte {
+	var b bytes.Buffer
+	for _, u := range utf16.Encode([]rune(s)) {
+		binary.Write(&b, binary.LittleEndian, u)
+	}
+	return b.Bytes()
+}
+
+func avPair(id uint16, value []byte) []byte {
+	pair := make([]byte, 4, 4+len(value))
+	binary.LittleEndian.PutUint16(pair, id)
+	binary.LittleEndian.PutUint16(pair[2:], uint16(len(value)))
+	return append(pair, value...)
+}
+
+// a CHALLENGE_MESSAGE like the one Exchange 2019 on Windows Server 2019 sends
+func challengeMessage(timestamp time.Time) []byte {
+	targetName := utf16le("CONTOSO")
+
+	filetime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(filetime, uint64(timestamp.UnixNano()/100)+116444736000000000)
+	var targetInfo []byte
+	targetInfo = append(targetInfo, avPair(msvAvNbDomainName, utf16le("CONTOSO"))...)
+	targetInfo = append(targetInfo, avPair(msvAvNbComputerName, utf16le("EXCH01"))...)
+	targetInfo = append(targetInfo, avPair(msvAvDnsDomainName, utf16le("contoso.local"))...)
+	targetInfo = append(targetInfo, avPair(msvAvDnsComputerName, utf16le("exch01.contoso.local"))...)
+	targetInfo = append(targetInfo, avPair(msvAvDnsTreeName, utf16le("contoso.local"))...)
+	targetInfo = append(targetInfo, avPair(msvAvTimestamp, filetime)...)
+	targetInfo = append(targetInfo, avPair(msvAvEOL, nil)...)
+
+	msg := make([]byte, 56)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(targetName)))
+	binary.LittleEndian.PutUint32(msg[16:], 56)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmNegotiateUnicode|ntlmNegotiateNTLM|ntlmNegotiateTargetInfo|ntlmNegotiateVersion)
+	copy(msg[24:32], "\x01\x23\x45\x67\x89\xab\xcd\xef")
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(56+len(targetName)))
+	// VERSION: 10.0 build 17763, NTLMSSP_REVISION_W2K3
+	msg[48], msg[49] = 10, 0
+	binary.LittleEndian.PutUint16(msg[50:], 17763)
+	msg[55] = 0x0f
+
+	msg = append(msg, targetName...)
+	return append(msg, targetInfo...)
+}
+
+func TestProbeNTLMInfo(t *testing.T) {
+	timestamp := time.Date(2024, 5, 17, 8, 30, 0, 0, time.UTC)
+	authorizations := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		authorizations = append(authorizations, authorization)
+		if strings.HasPrefix(authorization, "NTLM ") {
+			w.Header().Set("WWW-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(challengeMessage(timestamp)))
+		} else {
+			w.Header().Add("WWW-Authenticate", "Negotiate")
+			w.Header().Add("WWW-Authenticate", "NTLM")
+			w.Header().Add("WWW-Authenticate", `Basic realm="autodiscover.contoso.com"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	c := New_Client()
+	// the probe must not use them
+	c.Credentials = &Credentials{Username: "CONTOSO\\alice", Password: "secret"}
+
+	info, err := c.Probe_NTLMInfo(server.URL + "/autodiscover/autodiscover.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := NTLMInfo{
+		URL:             server.URL + "/autodiscover/autodiscover.xml",
+		Scheme:          "NTLM",
+		TargetName:      "CONTOSO",
+		NetBIOSDomain:   "CONTOSO",
+		NetBIOSComputer: "EXCH01",
+		DNSDomain:       "contoso.local",
+		DNSComputer:     "exch01.contoso.local",
+		DNSTree:         "contoso.local",
+		OSVersion:       "10.0.17763",
+		Timestamp:       timestamp,
+	}
+	if *info != want {
+		t.Errorf("got %+v, want %+v", *info, want)
+	}
+
+	if len(authorizations) != 2 || authorizations[0] != "" {
+		t.Fatalf("authorizations sent: %q", authorizations)
+	}
+	negotiate, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorizations[1], "NTLM "))
+	if err != nil || !bytes.Equal(negotiate, ntlmNegotiateMessage()) {
+		t.Errorf("second request doesn't carry the NEGOTIATE_MESSAGE: %q", authorizations[1])
+	}
+
+	if len(c.Endpoints) != 1 || c.Endpoints[0].AuthClass != AuthWindows || c.Endpoints[0].Authenticated != "" {
+		t.Errorf("endpoints: %+v", c.Endpoints)
+	}
+}
+
+func TestProbeNTLMInfoWithoutNTLM(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("credentials sent: %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="autodiscover"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	c := New_Client()
+	c.Credentials = &Credentials{Username: "alice", Password: "secret"}
+	if _, err := c.Probe_NTLMInfo(server.URL); err == nil {
+		t.Error("no error for an endpoint without NTLM")
+	}
+}
@@ -0,0 +1,75 @@
+package autodiscover
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+var mailProtocols = map[string]utils.MailProtocol{
+	"IMAP": utils.MailIMAP,
+	"POP3": utils.MailPOP3,
+	"SMTP": utils.MailSMTP,
+}
+
+// the IMAP, POP3 and SMTP protocols of the response in the model shared with autoconfig and SRV, nil if there is no Account.
+// Exchange protocols (EXCH, EXPR, WEB, mapiHttp) have no counterpart there and are left out.
+func (ad *Autodiscover) MailSettings(email_address string) *utils.MailSettings {
+	if ad.Response == nil || ad.Response.Account == nil {
+		return nil
+	}
+
+	settings := &utils.MailSettings{Source: utils.SourceAutodiscover, EmailAddress: email_address}
+	for _, p := range ad.Response.Account.Protocol {
+		protocol, ok := mailProtocols[strings.ToUpper(strings.TrimSpace(p.Type))]
+		if !ok || p.Server == "" {
+			continue
+		}
+
+		server := utils.MailServer{
+			Protocol:       protocol,
+			Hostname:       strings.ToLower(strings.TrimSuffix(strings.TrimSpace(p.Server), ".")),
+			Security:       protocolSecurity(p),
+			Username:       strings.TrimSpace(p.LoginName),
+			Authentication: "password-cleartext",
+		}
+		if strings.EqualFold(p.SPA, "on") {
+			server.Authentication = "NTLM"
+		}
+		if port, err := strconv.Atoi(strings.TrimSpace(p.Port)); err == nil && port > 0 && port <= 65535 {
+			server.Port = port
+		} else {
+			server.Port = utils.Default_Port(protocol, server.Security)
+		}
+		settings.Add(server)
+	}
+	return settings
+}
+
+// the settings a discovery ended with, for the address it ended with
+func (d *Discovery) MailSettings() *utils.MailSettings {
+	if d.Autodiscover == nil {
+		return nil
+	}
+	return d.Autodiscover.MailSettings(d.EmailAddress)
+}
+
+// MS-OXDSCLI 2.2.4.1.1.2.2: Encryption takes precedence over SSL, SSL defaults to "on".
+// like Thunderbird, "SSL" is implicit TLS and "TLS" is STARTTLS.
+func protocolSecurity(p Protocol) utils.Security {
+	switch strings.ToLower(strings.TrimSpace(p.Encryption)) {
+	case "none":
+		return utils.SecurityPlain
+	case "ssl":
+		return utils.SecurityTLS
+	case "tls":
+		return utils.SecuritySTARTTLS
+	case "auto":
+		return utils.SecurityUnknown
+	}
+	if strings.EqualFold(strings.TrimSpace(p.SSL), "off") {
+		return utils.SecurityPlain
+	}
+	return utils.SecurityTLS
+}
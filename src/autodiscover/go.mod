@@ -1,3 +1,23 @@
 module github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover
 
 go 1.22.3
+
+require (
+	github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig v1.0.0
+	github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils v1.0.0
+	golang.org/x/crypto v0.33.0
+)
+
+require (
+	github.com/miekg/dns v1.1.62 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+)
+
+replace github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig => ../autoconfig
+
+replace github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils => ../utils
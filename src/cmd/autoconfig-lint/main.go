@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
+)
+
+// lint autoconfig files saved by `Download_AutoconfigXML`
+// usage: autoconfig-lint [-domain example.com] [-types] <file or directory>...
+func main() {
+	domain := flag.String("domain", "", "queried email domain, taken from the file name (<email>.xml) if empty")
+	types := flag.Bool("types", false, "also count the domains advertising each server type in the directories")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: autoconfig-lint [-domain example.com] [-types] <file or directory>...")
+		os.Exit(2)
+	}
+
+	files := make([]string, 0)
+	for _, arg := range flag.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.xml"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		files = append(files, matches...)
+
+		if *types {
+			stats, err := autoconfig.Count_ServerTypes(arg)
+			if err != nil {
+				fmt.Printf("%s: %v\n", arg, err)
+				continue
+			}
+			fmt.Printf("%s: %d files, %d autoconfig documents\n", arg, stats.Files, stats.Parsed)
+			for _, t := range autoconfig.ServerTypes {
+				fmt.Printf("%s: %s %d\n", arg, t, stats.Domains[t])
+			}
+		}
+	}
+
+	failed := false
+	for _, file := range files {
+		var findings []autoconfig.Finding
+		if *domain != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Printf("%s: %v\n", file, err)
+				failed = true
+				continue
+			}
+			findings = autoconfig.Lint_AutoconfigXML(data, strings.ToLower(*domain))
+		} else {
+			var err error
+			findings, err = autoconfig.Lint_AutoconfigFile(file)
+			if err != nil {
+				fmt.Printf("%s: %v\n", file, err)
+				failed = true
+				continue
+			}
+		}
+
+		if len(findings) == 0 {
+			fmt.Printf("%s: ok\n", file)
+		}
+		for _, f := range findings {
+			fmt.Printf("%s: %v\n", file, f)
+		}
+		if autoconfig.Has_LintErrors(findings) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
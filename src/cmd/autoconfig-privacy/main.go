@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
+)
+
+// check whether querying the autoconfig hosts of email addresses leaks the address in cleartext
+// usage: autoconfig-privacy <email address>...
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: autoconfig-privacy <email address>...")
+		os.Exit(2)
+	}
+
+	results := make([]*autoconfig.PrivacyResult, 0, flag.NArg())
+	for _, email_address := range flag.Args() {
+		r, err := autoconfig.Analyze_Privacy(email_address)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		results = append(results, r)
+
+		fmt.Printf("%v (%v)\n", r.Domain, r.EmailAddress)
+		fmt.Printf("  https config: %v, http config: %v, http only: %v\n", r.HTTPSConfig, r.HTTPConfig, r.HTTPOnly)
+		if r.HTTPReachable {
+			fmt.Printf("  http://autoconfig.%v answered", r.Domain)
+			if r.RedirectLocation != "" {
+				fmt.Printf(", redirects to %v", r.RedirectLocation)
+			}
+			fmt.Println()
+		}
+		if r.HSTS {
+			fmt.Printf("  HSTS max-age=%d, includeSubDomains: %v\n", r.HSTSMaxAge, r.HSTSIncludeSubDomains)
+		}
+		if r.AddressExposed {
+			fmt.Println("  the email address is sent in cleartext")
+		}
+	}
+
+	report := autoconfig.Summarize_Privacy(results)
+	fmt.Printf("domains: %d, https config: %d, http config: %d, http only: %d, http redirects to https: %d, HSTS: %d, address exposed: %d\n",
+		report.Domains, report.HTTPSConfig, report.HTTPConfig, report.HTTPOnly, report.HTTPRedirectsToHTTPS, report.HSTS, report.AddressExposed)
+}
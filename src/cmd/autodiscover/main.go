@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// discover the Autodiscover settings of an email address the way MS-OXDISCO 3.1.5 describes
+// usage: autodiscover [-behavior spec|outlook|legacy] [-out dir] [-mapihttp] [-user name] <email address>
+// the password for -user is read from AUTODISCOVER_PASSWORD, or from the first line of stdin if that is unset,
+// so that it doesn't end up in the shell history or the process list
+func main() {
+	behavior := flag.String("behavior", string(autodiscover.BehaviorSpec), "client whose candidate order is used: spec, outlook or legacy")
+	out := flag.String("out", "", "directory to save the response in as <email>.xml, printed if empty")
+	user := flag.String("user", "", "user name to answer authentication challenges with, the password is read from "+passwordEnv+" or stdin")
+	mapiHttp := flag.Bool("mapihttp", false, "advertise MAPI/HTTP with X-MapiHttpCapability and X-AnchorMailbox")
+	maxRedirects := flag.Int("max-redirects", autodiscover.DefaultMaxRedirects, "redirects followed before giving up")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: autodiscover [-behavior spec|outlook|legacy] [-out dir] [-mapihttp] [-user name] <email address>")
+		os.Exit(2)
+	}
+
+	switch autodiscover.ClientBehavior(*behavior) {
+	case autodiscover.BehaviorSpec, autodiscover.BehaviorOutlook, autodiscover.BehaviorLegacy:
+	default:
+		fmt.Printf("unknown behavior: %v\n", *behavior)
+		os.Exit(2)
+	}
+
+	addr, err := utils.Parse_EmailAddress(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	client := autodiscover.New_Client()
+	client.MaxRedirects = *maxRedirects
+	if *mapiHttp {
+		client.MapiHttpCapability = 1
+		client.AnchorMailbox = true
+	}
+	if *user != "" {
+		password, err := readPassword()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		client.Credentials = &autodiscover.Credentials{Username: *user, Password: password}
+	}
+
+	d, err := client.Discover(addr.String(), autodiscover.ClientBehavior(*behavior))
+
+	for _, e := range client.Endpoints {
+		fmt.Printf("%v %v: %v %v\n", e.Method, e.URL, e.StatusCode, e.AuthClass)
+		if e.Refused != "" {
+			fmt.Printf("  %v\n", e.Refused)
+		}
+	}
+	if err != nil {
+		fmt.Println(err)
+		fmt.Printf("result: %v\n", autodiscover.Categorize_Result(err))
+		os.Exit(1)
+	}
+
+	for _, r := range d.Redirects {
+		fmt.Printf("redirected to: %v\n", r)
+	}
+	fmt.Printf("settings for %v from %v\n", d.EmailAddress, d.URL)
+	if d.Autodiscover != nil {
+		if m := d.Autodiscover.MapiHttp(); m != nil {
+			fmt.Printf("MAPI/HTTP mailstore: %v, address book: %v\n", m.MailStoreExternal, m.AddressBookExternal)
+		}
+	}
+
+	if *out == "" {
+		fmt.Println(string(d.Body))
+		return
+	}
+	xmlpath := filepath.Join(*out, addr.FileName()+".xml")
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(xmlpath, d.Body, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("saved to %v\n", xmlpath)
+}
+
+const passwordEnv = "AUTODISCOVER_PASSWORD"
+
+func readPassword() (string, error) {
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		return password, nil
+	}
+	fmt.Fprintf(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password in %v or on stdin: %v", passwordEnv, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
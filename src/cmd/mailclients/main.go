@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/analysis"
+)
+
+// show the configuration each mail client would end up with for an email address
+// usage: mailclients [-suffixlist path] [-clients thunderbird,outlook,...] <email address>
+func main() {
+	suffixlist := flag.String("suffixlist", "../download/public_suffix_list.josn", "public suffix list saved by Get_PublicSuffixList")
+	clients := flag.String("clients", "", "comma separated profiles to emulate, all if empty")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: mailclients [-suffixlist path] [-clients thunderbird,outlook,...] <email address>")
+		os.Exit(2)
+	}
+
+	var profiles []*analysis.ClientProfile
+	if *clients != "" {
+		for _, name := range strings.Split(*clients, ",") {
+			p := analysis.Find_Profile(strings.TrimSpace(name))
+			if p == nil {
+				fmt.Printf("unknown client %q\n", name)
+				os.Exit(2)
+			}
+			profiles = append(profiles, p)
+		}
+	}
+
+	results, err := analysis.Emulate_Clients(flag.Arg(0), *suffixlist, profiles)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, r := range results {
+		if r.Settings == nil {
+			fmt.Printf("%v: nothing found\n", r.Profile)
+			for source, err := range r.Collection.Errors {
+				fmt.Printf("  %v: %v\n", source, err)
+			}
+			continue
+		}
+		fmt.Printf("%v: %v\n", r.Profile, r.Used)
+		if s := r.Settings.Preferred_Incoming(); s != nil {
+			fmt.Printf("  incoming %v\n", s)
+		}
+		if s := r.Settings.Preferred_Outgoing(); s != nil {
+			fmt.Printf("  outgoing %v\n", s)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/analysis"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+)
+
+// compare what autoconfig, Autodiscover and the RFC 6186 SRV records say about email addresses
+// usage: mailconsistency [-suffixlist path] [-behavior spec|outlook|legacy] <email address>...
+func main() {
+	suffixlist := flag.String("suffixlist", "../download/public_suffix_list.josn", "public suffix list saved by Get_PublicSuffixList")
+	behavior := flag.String("behavior", string(autodiscover.BehaviorSpec), "Autodiscover client behavior: spec, outlook or legacy")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: mailconsistency [-suffixlist path] [-behavior spec|outlook|legacy] <email address>...")
+		os.Exit(2)
+	}
+
+	switch autodiscover.ClientBehavior(*behavior) {
+	case autodiscover.BehaviorSpec, autodiscover.BehaviorOutlook, autodiscover.BehaviorLegacy:
+	default:
+		fmt.Printf("unknown behavior: %v\n", *behavior)
+		os.Exit(2)
+	}
+
+	reports := make([]*analysis.ConsistencyReport, 0, flag.NArg())
+	for _, email_address := range flag.Args() {
+		c, err := analysis.Collect_MailSettings(email_address, *suffixlist, autodiscover.ClientBehavior(*behavior))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		fmt.Printf("%v\n", email_address)
+		for _, source := range analysis.DefaultPrecedence {
+			settings := c.Settings[source]
+			if settings == nil {
+				fmt.Printf("  %v: %v\n", source, c.Errors[source])
+				continue
+			}
+			fmt.Printf("  %v:\n", source)
+			for _, s := range settings.Incoming {
+				fmt.Printf("    incoming %v\n", s)
+			}
+			for _, s := range settings.Outgoing {
+				fmt.Printf("    outgoing %v\n", s)
+			}
+		}
+
+		r := c.Consistency()
+		reports = append(reports, r)
+		for _, conflict := range r.Conflicts {
+			fmt.Printf("  %v\n", conflict)
+		}
+		fmt.Printf("  consistent: %v, a client ends up with: %v\n", r.Consistent, r.Used)
+	}
+
+	stats := analysis.Summarize_Consistency(reports)
+	fmt.Printf("addresses: %d, consistent: %d, inconsistent: %d, single source: %d, none: %d\n",
+		stats.Addresses, stats.Consistent, stats.Inconsistent, stats.Single, stats.None)
+}
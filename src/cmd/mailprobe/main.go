@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/analysis"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/probe"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// probe the IMAP, POP3 and SMTP servers that autoconfig, Autodiscover and the RFC 6186 SRV records name for an email address
+// usage: mailprobe [-suffixlist path] [-ehlo name] [-tls] <email address>
+func main() {
+	suffixlist := flag.String("suffixlist", "../download/public_suffix_list.josn", "public suffix list saved by Get_PublicSuffixList")
+	ehlo := flag.String("ehlo", "localhost", "name sent with SMTP EHLO")
+	inspectTLS := flag.Bool("tls", false, "inspect the TLS certificate and versions of each server")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: mailprobe [-suffixlist path] [-ehlo name] [-tls] <email address>")
+		os.Exit(2)
+	}
+
+	c, err := analysis.Collect_MailSettings(flag.Arg(0), *suffixlist, autodiscover.BehaviorSpec)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	for _, source := range analysis.DefaultPrecedence {
+		if err, ok := c.Errors[source]; ok {
+			fmt.Printf("%v: %v\n", source, err)
+		}
+	}
+	if _, ok := c.Settings[utils.SourceSRV]; ok {
+		fmt.Printf("srv: DNSSEC %v\n", c.SRVDNSSEC.Status)
+	}
+
+	// each server is probed once, labelled with every source naming it
+	endpoints := make([]probe.Endpoint, 0)
+	sources := make(map[probe.Endpoint][]string)
+	for _, source := range analysis.DefaultPrecedence {
+		settings := c.Settings[source]
+		if settings == nil {
+			continue
+		}
+		for _, s := range append(append([]utils.MailServer{}, settings.Incoming...), settings.Outgoing...) {
+			endpoint, ok := probe.Endpoint_FromMailServer(s)
+			if !ok {
+				fmt.Printf("%v: can't probe %v\n", source, s)
+				continue
+			}
+			if _, seen := sources[endpoint]; !seen {
+				endpoints = append(endpoints, endpoint)
+			}
+			sources[endpoint] = append(sources[endpoint], string(source))
+		}
+	}
+
+	if len(endpoints) == 0 {
+		fmt.Printf("no mail servers for %v\n", c.EmailAddress)
+		os.Exit(1)
+	}
+
+	prober := probe.New_Prober()
+	prober.EHLOName = *ehlo
+	inspector := probe.New_Inspector()
+	inspector.EHLOName = *ehlo
+	inspector.CheckVersions = true
+
+	for _, endpoint := range endpoints {
+		r := prober.Probe(endpoint)
+		fmt.Printf("%v (%v)\n", endpoint, strings.Join(sources[endpoint], ", "))
+		if r.Error != "" {
+			fmt.Printf("  error: %v\n", r.Error)
+		}
+		if !r.Reachable {
+			continue
+		}
+		fmt.Printf("  banner: %v\n", r.Banner)
+		fmt.Printf("  capabilities: %v\n", strings.Join(r.Capabilities, ", "))
+		fmt.Printf("  STARTTLS: %v, AUTH: %v\n", r.STARTTLS, strings.Join(r.AuthMechanisms, " "))
+
+		if !*inspectTLS || (!endpoint.ImplicitTLS && !r.STARTTLS) {
+			continue
+		}
+		i := inspector.Inspect(endpoint)
+		if i.Error != "" {
+			fmt.Printf("  TLS error: %v\n", i.Error)
+			continue
+		}
+		fmt.Printf("  TLS: %v %v, versions: %v\n", i.Version, i.CipherSuite, strings.Join(i.SupportedVersions, ", "))
+		fmt.Printf("  certificate: verified %v, hostname match %v, expired %v, SANs: %v\n", i.Verified, i.HostnameMatch, i.Expired, strings.Join(i.SANs, ", "))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/analysis"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/probe"
+)
+
+// grade the transport security of the configuration discoverable for email addresses, best first
+// usage: mailscore [-suffixlist path] [-notls] <email address>...
+func main() {
+	suffixlist := flag.String("suffixlist", "../download/public_suffix_list.josn", "public suffix list saved by Get_PublicSuffixList")
+	noTLS := flag.Bool("notls", false, "don't connect to the servers to inspect their certificates")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: mailscore [-suffixlist path] [-notls] <email address>...")
+		os.Exit(2)
+	}
+
+	inspector := probe.New_Inspector()
+	inspector.CheckVersions = true
+
+	scores := make([]*analysis.DomainScore, 0, flag.NArg())
+	for _, email_address := range flag.Args() {
+		c, err := analysis.Collect_MailSettings(email_address, *suffixlist, autodiscover.BehaviorSpec)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		var inspections []*probe.TLSInspection
+		if !*noTLS {
+			inspections = analysis.Inspect_Collection(c, inspector)
+		}
+		scores = append(scores, analysis.Score_Collection(c, inspections))
+	}
+
+	analysis.Rank_Scores(scores)
+	for _, s := range scores {
+		fmt.Printf("%v %v %d (%v)\n", s.Grade, s.Domain, s.Score, s.EmailAddress)
+		for _, r := range s.Reasons {
+			fmt.Printf("  %v\n", r)
+		}
+	}
+
+	stats := analysis.Summarize_Scores(scores)
+	fmt.Printf("domains: %d, mean score: %.1f, A: %d, B: %d, C: %d, D: %d, F: %d\n", stats.Domains, stats.Mean,
+		stats.ByGrade[analysis.GradeA], stats.ByGrade[analysis.GradeB], stats.ByGrade[analysis.GradeC], stats.ByGrade[analysis.GradeD], stats.ByGrade[analysis.GradeF])
+}
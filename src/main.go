@@ -2,11 +2,15 @@ package main
 
 import (
 	"fmt"
+	"os"
+
 	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autoconfig"
 	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/autodiscover"
-	// "github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
 )
 
+// download the autoconfig and Autodiscover files of the addresses given as arguments and count the results
+// usage: go run main.go <email>...
 func main() {
 	// download_sufffixlist()
 	// err := autoconfig.Get_PublicSuffixList("../download/public_suffix_list.josn")
@@ -16,17 +20,24 @@ func main() {
 	// 	fmt.Println("Get_PublicSuffixList success")
 	// }
 
-	err := autoconfig.Download_AutoconfigXML("1397798409@qq.com", "../download/public_suffix_list.josn", "../download/autoconfig")
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println("Get_UrlListAutoconfigXML success")
+	email_addresses := os.Args[1:]
+	if len(email_addresses) == 0 {
+		email_addresses = []string{"1397798409@qq.com"}
 	}
 
-	err = autodiscover.Download_AutodiscoverXML("kyc98409@example.com", "../download/autodiscover")
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println("Download_UrlListAutodiscoverXML success")
+	stats := autoconfig.Download_AutoconfigXMLs(email_addresses, "../download/public_suffix_list.josn", "../download/autoconfig")
+	printStats("autoconfig", stats)
+
+	stats = autodiscover.Download_AutodiscoverXMLs(email_addresses, "../download/autodiscover")
+	printStats("autodiscover", stats)
+}
+
+// false positives are HTTP 200 responses that were rejected, e.g. parking pages, and are not counted as found
+func printStats(mechanism string, stats utils.ResponseStats) {
+	fmt.Printf("%v: found %d, not found %d, false positives %d\n", mechanism, stats.Found, stats.NotFound, stats.FalsePositive)
+	for _, class := range []utils.ResponseClass{utils.ResponseEmpty, utils.ResponseHTML, utils.ResponseNotXML, utils.ResponseWrongRoot, utils.ResponseSoft404} {
+		if stats.Rejected[class] > 0 {
+			fmt.Printf("  rejected %v: %d\n", class, stats.Rejected[class])
+		}
 	}
 }
@@ -0,0 +1,207 @@
+package probe
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+type Protocol string
+
+const (
+	ProtocolIMAP Protocol = "imap"
+	ProtocolPOP3 Protocol = "pop3"
+	ProtocolSMTP Protocol = "smtp"
+	// discovery endpoints, only for TLS inspection
+	ProtocolHTTPS Protocol = "https"
+)
+
+// a server a client was told to use, by SRV, autoconfig or Autodiscover
+type Endpoint struct {
+	Protocol    Protocol
+	Host        string
+	Port        int
+	ImplicitTLS bool // TLS from the first byte (993, 995, 465), otherwise plain text with STARTTLS
+}
+
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+func (e Endpoint) String() string {
+	if e.ImplicitTLS {
+		return fmt.Sprintf("%v+tls://%v", e.Protocol, e.Address())
+	}
+	return fmt.Sprintf("%v://%v", e.Protocol, e.Address())
+}
+
+// what an endpoint answered
+type Result struct {
+	Endpoint       Endpoint
+	Reachable      bool     // the TCP (and implicit TLS) connection succeeded
+	Banner         string   // the greeting, without the status code or "* OK"
+	Capabilities   []string // as sent, e.g. "IMAP4rev1", "SASL PLAIN LOGIN", "8BITMIME"
+	STARTTLS       bool     // STARTTLS (IMAP, SMTP) or STLS (POP3) is offered
+	AuthMechanisms []string // SASL mechanisms, upper case
+	LoginDisabled  bool     // IMAP LOGINDISABLED, plain text login is refused before STARTTLS
+	Duration       time.Duration
+	Error          string // why the probe stopped, "" if it completed
+}
+
+type Prober struct {
+	Timeout   time.Duration // for the whole conversation with one endpoint
+	TLSConfig *tls.Config   // for implicit TLS
+	EHLOName  string        // sent with SMTP EHLO
+}
+
+func New_Prober() *Prober {
+	return &Prober{
+		Timeout: 15 * time.Second,
+		// certificates are not the concern of the probe, see the TLS inspection
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		EHLOName:  "localhost",
+	}
+}
+
+func Probe_Endpoint(e Endpoint) *Result {
+	return New_Prober().Probe(e)
+}
+
+// probe the endpoints one after another
+func Probe_Endpoints(endpoints []Endpoint) []*Result {
+	p := New_Prober()
+	results := make([]*Result, 0, len(endpoints))
+	for _, e := range endpoints {
+		results = append(results, p.Probe(e))
+	}
+	return results
+}
+
+// connect to e, read the greeting and ask for the capabilities. Nothing is authenticated.
+func (p *Prober) Probe(e Endpoint) *Result {
+	result := &Result{Endpoint: e}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	conn, err := p.dial(e)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	result.Reachable = true
+	conn.SetDeadline(start.Add(p.Timeout))
+
+	r := bufio.NewReader(conn)
+	switch e.Protocol {
+	case ProtocolIMAP:
+		err = probeIMAP(conn, r, result)
+	case ProtocolPOP3:
+		err = probePOP3(conn, r, result)
+	case ProtocolSMTP:
+		err = probeSMTP(conn, r, result, p.EHLOName)
+	default:
+		err = fmt.Errorf("unknown protocol %q", e.Protocol)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (p *Prober) dial(e Endpoint) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.Timeout}
+	if !e.ImplicitTLS {
+		return dialer.Dial("tcp", e.Address())
+	}
+
+	config := p.TLSConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = e.Host
+	}
+	return tls.DialWithDialer(dialer, "tcp", e.Address(), config)
+}
+
+// the endpoint an RFC 6186 SRV record describes, false for services that are not IMAP, POP3 or SMTP submission
+// and for the target "." that says the service is not available (RFC 2782)
+func Endpoint_FromSRV(t utils.SRVTarget) (Endpoint, bool) {
+	e := Endpoint{Host: t.Target, Port: int(t.Port)}
+	if strings.TrimSuffix(t.Target, ".") == "" {
+		return e, false
+	}
+	switch t.Service {
+	case "_imap._tcp":
+		e.Protocol = ProtocolIMAP
+	case "_imaps._tcp":
+		e.Protocol, e.ImplicitTLS = ProtocolIMAP, true
+	case "_pop3._tcp":
+		e.Protocol = ProtocolPOP3
+	case "_pop3s._tcp":
+		e.Protocol, e.ImplicitTLS = ProtocolPOP3, true
+	case "_submission._tcp":
+		e.Protocol = ProtocolSMTP
+	case "_submissions._tcp":
+		e.Protocol, e.ImplicitTLS = ProtocolSMTP, true
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+// the endpoint of a server from a configuration, false if the security is unknown and it can't be told how to connect
+func Endpoint_FromMailServer(s utils.MailServer) (Endpoint, bool) {
+	e := Endpoint{Protocol: Protocol(s.Protocol), Host: s.Hostname, Port: s.Port, ImplicitTLS: s.Security == utils.SecurityTLS}
+	return e, s.Security != utils.SecurityUnknown && s.Port != 0
+}
+
+// the endpoint of an https url, e.g. an Autodiscover or autoconfig url
+func Endpoint_FromURL(rawurl string) (Endpoint, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if u.Scheme != "https" {
+		return Endpoint{}, fmt.Errorf("not an https url: %v", rawurl)
+	}
+	port := 443
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return Endpoint{}, err
+		}
+	}
+	return Endpoint{Protocol: ProtocolHTTPS, Host: u.Hostname(), Port: port, ImplicitTLS: true}, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeLine(conn net.Conn, line string) error {
+	_, err := conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func addMechanisms(result *Result, mechanisms []string) {
+	for _, m := range mechanisms {
+		m = strings.ToUpper(m)
+		known := false
+		for _, have := range result.AuthMechanisms {
+			known = known || have == m
+		}
+		if !known && m != "" {
+			result.AuthMechanisms = append(result.AuthMechanisms, m)
+		}
+	}
+}
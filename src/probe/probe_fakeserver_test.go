@@ -0,0 +1,193 @@
+package probe
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// a minimal IMAP, POP3 or SMTP server on localhost that greets, lists its capabilities and says goodbye.
+// it lets the prober be exercised without a real mail server.
+type fakeServer struct {
+	Protocol     Protocol
+	Banner       string
+	Capabilities []string // IMAP: "IMAP4rev1 STARTTLS AUTH=PLAIN", POP3: CAPA lines, SMTP: EHLO keywords
+
+	implicitTLS bool
+	starttls    *tls.Config // answer STARTTLS / STLS with this
+	listener    net.Listener
+	wg          sync.WaitGroup
+}
+
+// listen on a random localhost port, with implicit TLS if config is not nil
+func startFakeServer(protocol Protocol, banner string, capabilities []string, config *tls.Config) (*fakeServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
+
+	s := &fakeServer{Protocol: protocol, Banner: banner, Capabilities: capabilities, implicitTLS: config != nil, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// listen on a random localhost port in plain text and upgrade with config when the client asks for STARTTLS or STLS.
+// the STARTTLS capability is not added to capabilities.
+func startFakeSTARTTLSServer(protocol Protocol, banner string, capabilities []string, config *tls.Config) (*fakeServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fakeServer{Protocol: protocol, Banner: banner, Capabilities: capabilities, starttls: config, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// the endpoint to probe the server at
+func (s *fakeServer) Endpoint() Endpoint {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return Endpoint{Protocol: s.Protocol, Host: addr.IP.String(), Port: addr.Port, ImplicitTLS: s.implicitTLS}
+}
+
+func (s *fakeServer) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := func(lines ...string) {
+		for _, l := range lines {
+			conn.Write([]byte(l + "\r\n"))
+		}
+	}
+	upgrade := func() bool {
+		tlsConn := tls.Server(conn, s.starttls)
+		if tlsConn.Handshake() != nil {
+			return false
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+		return true
+	}
+
+	switch s.Protocol {
+	case ProtocolIMAP:
+		w("* OK " + s.Banner)
+	case ProtocolPOP3:
+		w("+OK " + s.Banner)
+	case ProtocolSMTP:
+		w("220 " + s.Banner)
+	}
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch s.Protocol {
+		case ProtocolIMAP:
+			if len(fields) < 2 {
+				w("* BAD missing tag")
+				continue
+			}
+			tag, command := fields[0], strings.ToUpper(fields[1])
+			switch command {
+			case "CAPABILITY":
+				w("* CAPABILITY "+strings.Join(s.Capabilities, " "), tag+" OK CAPABILITY completed")
+			case "LOGOUT":
+				w("* BYE", tag+" OK LOGOUT completed")
+				return
+			case "STARTTLS":
+				if s.starttls == nil {
+					w(tag + " BAD STARTTLS not supported")
+					continue
+				}
+				w(tag + " OK begin TLS negotiation now")
+				if !upgrade() {
+					return
+				}
+			default:
+				w(tag + " BAD unknown command")
+			}
+		case ProtocolPOP3:
+			switch strings.ToUpper(fields[0]) {
+			case "CAPA":
+				w("+OK")
+				w(s.Capabilities...)
+				w(".")
+			case "QUIT":
+				w("+OK bye")
+				return
+			case "STLS":
+				if s.starttls == nil {
+					w("-ERR STLS not supported")
+					continue
+				}
+				w("+OK begin TLS negotiation")
+				if !upgrade() {
+					return
+				}
+			default:
+				w("-ERR unknown command")
+			}
+		case ProtocolSMTP:
+			switch strings.ToUpper(fields[0]) {
+			case "EHLO":
+				reply := append([]string{"fake greets " + strings.Join(fields[1:], " ")}, s.Capabilities...)
+				for i, l := range reply {
+					sep := "-"
+					if i == len(reply)-1 {
+						sep = " "
+					}
+					w("250" + sep + l)
+				}
+			case "QUIT":
+				w("221 bye")
+				return
+			case "STARTTLS":
+				if s.starttls == nil {
+					w("502 STARTTLS not implemented")
+					continue
+				}
+				w("220 ready to start TLS")
+				if !upgrade() {
+					return
+				}
+			default:
+				w("502 " + strconv.Quote(fields[0]) + " not implemented")
+			}
+		}
+	}
+}
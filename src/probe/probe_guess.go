@@ -0,0 +1,216 @@
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// hostname prefixes tried under the email domain, in Thunderbird's guessConfig order. "" is the domain itself.
+var GuessHostnames = map[utils.MailProtocol][]string{
+	utils.MailIMAP: {"imap.", "mail.", ""},
+	utils.MailPOP3: {"pop3.", "pop.", "mail.", ""},
+	utils.MailSMTP: {"smtp.", "mail.", ""},
+}
+
+// ports tried for each hostname, implicit TLS first
+var GuessPorts = map[utils.MailProtocol][]int{
+	utils.MailIMAP: {993, 143},
+	utils.MailPOP3: {995, 110},
+	utils.MailSMTP: {465, 587, 25},
+}
+
+var implicitTLSPorts = map[int]bool{993: true, 995: true, 465: true}
+
+// one hostname and port that was tried
+type Guess struct {
+	Server     utils.MailServer
+	Probe      *Result
+	TLS        *TLSInspection // nil if no TLS was possible
+	Works      bool           // the server greeted, answered the capability request and, on a TLS port, completed the handshake
+	Confidence float64        // 0 - 1, how sure we are this is the server to use
+	Reasons    []string       // what the confidence is made of
+
+	prefix      string // of `GuessHostnames`
+	implicitTLS bool   // the port is one of `implicitTLSPorts`
+}
+
+// the best working guess for each protocol
+type GuessResult struct {
+	EmailAddress string
+	IMAP         *Guess // nil if nothing works
+	POP3         *Guess
+	SMTP         *Guess
+	Tried        []*Guess
+	Settings     *utils.MailSettings // the working guesses, IMAP before POP3 like Thunderbird
+}
+
+type Guesser struct {
+	Prober    *Prober
+	Inspector *Inspector
+}
+
+func New_Guesser() *Guesser {
+	g := &Guesser{Prober: New_Prober(), Inspector: New_Inspector()}
+	g.Prober.Timeout = 10 * time.Second
+	g.Inspector.Timeout = 10 * time.Second
+	return g
+}
+
+func Guess_Config(email_address string) (*GuessResult, error) {
+	return New_Guesser().Guess(email_address)
+}
+
+// try the `GuessHostnames` on the `GuessPorts` of the domain of email_address for IMAP, POP3 and SMTP
+func (g *Guesser) Guess(email_address string) (*GuessResult, error) {
+	addr, err := utils.Parse_EmailAddress(email_address)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GuessResult{EmailAddress: email_address}
+	result.Settings = &utils.MailSettings{Source: utils.SourceGuess, EmailAddress: email_address}
+	for _, protocol := range []utils.MailProtocol{utils.MailIMAP, utils.MailPOP3, utils.MailSMTP} {
+		best, tried := g.Guess_Protocol(addr.ASCIIDomain, protocol)
+		result.Tried = append(result.Tried, tried...)
+		switch protocol {
+		case utils.MailIMAP:
+			result.IMAP = best
+		case utils.MailPOP3:
+			result.POP3 = best
+		case utils.MailSMTP:
+			result.SMTP = best
+		}
+		if best != nil {
+			result.Settings.Add(best.Server)
+		}
+	}
+	return result, nil
+}
+
+// try every candidate of protocol under domain at once, see `bestGuess` for which one wins
+func (g *Guesser) Guess_Protocol(domain string, protocol utils.MailProtocol) (*Guess, []*Guess) {
+	tried := make([]*Guess, 0)
+	for _, prefix := range GuessHostnames[protocol] {
+		for _, port := range GuessPorts[protocol] {
+			server := utils.MailServer{Protocol: protocol, Hostname: prefix + domain, Port: port}
+			tried = append(tried, &Guess{Server: server, prefix: prefix, implicitTLS: implicitTLSPorts[port]})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, guess := range tried {
+		wg.Add(1)
+		go func(guess *Guess) {
+			defer wg.Done()
+			g.try(guess)
+		}(guess)
+	}
+	wg.Wait()
+
+	return bestGuess(tried), tried
+}
+
+// the working guess a client should use: one whose certificate it accepts without asking,
+// then the most secure one, then the most confident one. nil if nothing works.
+func bestGuess(tried []*Guess) *Guess {
+	working := make([]*Guess, 0)
+	for _, guess := range tried {
+		if guess.Works {
+			working = append(working, guess)
+		}
+	}
+	if len(working) == 0 {
+		return nil
+	}
+	// stable, so ties keep the order of the candidates
+	sort.SliceStable(working, func(i, j int) bool {
+		if working[i].certificateUsable() != working[j].certificateUsable() {
+			return working[i].certificateUsable()
+		}
+		if securityRank[working[i].Server.Security] != securityRank[working[j].Server.Security] {
+			return securityRank[working[i].Server.Security] > securityRank[working[j].Server.Security]
+		}
+		return working[i].Confidence > working[j].Confidence
+	})
+	return working[0]
+}
+
+// whether the guess has TLS with a certificate that is valid for its hostname
+func (guess *Guess) certificateUsable() bool {
+	if guess.Server.Security == utils.SecurityPlain || guess.TLS == nil || guess.TLS.Error != "" {
+		return false
+	}
+	return guess.TLS.Verified && guess.TLS.HostnameMatch
+}
+
+var securityRank = map[utils.Security]int{
+	utils.SecurityTLS:      2,
+	utils.SecuritySTARTTLS: 1,
+	utils.SecurityPlain:    0,
+}
+
+// probe one candidate, then inspect its TLS and rate it
+func (g *Guesser) try(guess *Guess) {
+	s := &guess.Server
+	e := Endpoint{Protocol: Protocol(s.Protocol), Host: s.Hostname, Port: s.Port, ImplicitTLS: guess.implicitTLS}
+
+	guess.Probe = g.Prober.Probe(e)
+	if !guess.Probe.Reachable || guess.Probe.Error != "" {
+		return
+	}
+	guess.Works = true
+	confidence := func(delta float64, format string, args ...interface{}) {
+		guess.Confidence += delta
+		guess.Reasons = append(guess.Reasons, fmt.Sprintf("%+.1f ", delta)+fmt.Sprintf(format, args...))
+	}
+	confidence(0.4, "answers as %v", s.Protocol)
+
+	switch {
+	case e.ImplicitTLS:
+		s.Security = utils.SecurityTLS
+	case guess.Probe.STARTTLS:
+		s.Security = utils.SecuritySTARTTLS
+	default:
+		s.Security = utils.SecurityPlain
+	}
+
+	if s.Security != utils.SecurityPlain {
+		guess.TLS = g.Inspector.Inspect(e)
+		switch {
+		case guess.TLS.Error != "" && s.Security == utils.SecuritySTARTTLS:
+			// STARTTLS is advertised but doesn't work, a client would have to go without
+			s.Security = utils.SecurityPlain
+			confidence(-0.1, "STARTTLS fails: %v", guess.TLS.Error)
+		case guess.TLS.Error != "":
+			// the probe got through, but a client's handshake doesn't, so there is nothing to use
+			guess.Works = false
+			guess.Confidence = 0
+			guess.Reasons = append(guess.Reasons, "TLS handshake fails: "+guess.TLS.Error)
+			return
+		default:
+			confidence(0.2, "%v", guess.TLS.Version)
+			if guess.TLS.Verified && guess.TLS.HostnameMatch {
+				confidence(0.3, "certificate valid for %v", s.Hostname)
+			} else if guess.TLS.Verified {
+				confidence(0.1, "certificate valid, but not for %v", s.Hostname)
+			}
+		}
+	}
+
+	if guess.prefix != "" && guess.prefix != "mail." {
+		confidence(0.1, "hostname names the protocol")
+	}
+	if s.Protocol == utils.MailSMTP && s.Port == 25 {
+		confidence(-0.2, "port 25 is meant for relaying between servers, not for submission")
+	}
+	if guess.Confidence < 0 {
+		guess.Confidence = 0
+	}
+	if guess.Confidence > 1 {
+		guess.Confidence = 1
+	}
+}
@@ -0,0 +1,201 @@
+package probe
+
+import (
+	"crypto/tls"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// the guess `Guesser.Guess_Protocol` would make for the fake server s if it were under prefix
+func testGuess(s *fakeServer, prefix string) *Guess {
+	e := s.Endpoint()
+	server := utils.MailServer{Protocol: utils.MailProtocol(e.Protocol), Hostname: e.Host, Port: e.Port}
+	return &Guess{Server: server, prefix: prefix, implicitTLS: e.ImplicitTLS}
+}
+
+func testGuesser(ca *testCA) *Guesser {
+	g := New_Guesser()
+	g.Prober.Timeout = 5 * time.Second
+	g.Inspector.Timeout = 5 * time.Second
+	g.Inspector.RootCAs = ca.Pool()
+	return g
+}
+
+func TestGuessTry(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      func(config *tls.Config) (*fakeServer, error)
+		names      []string // of the server certificate
+		prefix     string
+		works      bool
+		security   utils.Security
+		usable     bool
+		confidence float64
+	}{
+		{
+			name: "tls",
+			start: func(config *tls.Config) (*fakeServer, error) {
+				return startFakeServer(ProtocolIMAP, "ready", nil, config)
+			},
+			names: []string{"127.0.0.1"}, prefix: "imap.",
+			works: true, security: utils.SecurityTLS, usable: true, confidence: 1,
+		},
+		{
+			name: "tls with a certificate for another name",
+			start: func(config *tls.Config) (*fakeServer, error) {
+				return startFakeServer(ProtocolIMAP, "ready", nil, config)
+			},
+			names: []string{"mail.example.com"}, prefix: "mail.",
+			works: true, security: utils.SecurityTLS, usable: false, confidence: 0.7,
+		},
+		{
+			name: "starttls",
+			start: func(config *tls.Config) (*fakeServer, error) {
+				return startFakeSTARTTLSServer(ProtocolSMTP, "ready", []string{"STARTTLS"}, config)
+			},
+			names: []string{"127.0.0.1"}, prefix: "",
+			works: true, security: utils.SecuritySTARTTLS, usable: true, confidence: 0.9,
+		},
+		{
+			name: "starttls advertised, but refused",
+			start: func(config *tls.Config) (*fakeServer, error) {
+				return startFakeSTARTTLSServer(ProtocolPOP3, "ready", []string{"STLS"}, nil)
+			},
+			names: []string{"127.0.0.1"}, prefix: "pop.",
+			works: true, security: utils.SecurityPlain, usable: false, confidence: 0.4,
+		},
+		{
+			name: "plain",
+			start: func(config *tls.Config) (*fakeServer, error) {
+				return startFakeSTARTTLSServer(ProtocolIMAP, "ready", []string{"IMAP4rev1"}, nil)
+			},
+			names: []string{"127.0.0.1"}, prefix: "",
+			works: true, security: utils.SecurityPlain, usable: false, confidence: 0.4,
+		},
+		{
+			// the prober gets through with a client certificate, the handshake of a client without one fails
+			name: "tls handshake fails",
+			start: func(config *tls.Config) (*fakeServer, error) {
+				config.MaxVersion = tls.VersionTLS12
+				config.ClientAuth = tls.RequireAnyClientCert
+				return startFakeServer(ProtocolIMAP, "ready", nil, config)
+			},
+			names: []string{"127.0.0.1"}, prefix: "imap.",
+			works: false, usable: false, confidence: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ca, config := testTLSConfig(t, time.Now().Add(time.Hour), test.names...)
+			s, err := test.start(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			g := testGuesser(ca)
+			g.Prober.TLSConfig = &tls.Config{InsecureSkipVerify: true, Certificates: config.Certificates}
+			guess := testGuess(s, test.prefix)
+			g.try(guess)
+
+			if guess.Probe == nil || !guess.Probe.Reachable {
+				t.Fatalf("probe %+v", guess.Probe)
+			}
+			if guess.Works != test.works || guess.certificateUsable() != test.usable {
+				t.Errorf("works %v, usable certificate %v: %q", guess.Works, guess.certificateUsable(), guess.Reasons)
+			}
+			if test.works && guess.Server.Security != test.security {
+				t.Errorf("security %q", guess.Server.Security)
+			}
+			if math.Abs(guess.Confidence-test.confidence) > 1e-9 {
+				t.Errorf("confidence %v, want %v: %q", guess.Confidence, test.confidence, guess.Reasons)
+			}
+			if len(guess.Reasons) == 0 {
+				t.Error("no reasons")
+			}
+		})
+	}
+}
+
+func TestBestGuess(t *testing.T) {
+	guess := func(security utils.Security, verified bool, hostnameMatch bool, works bool, confidence float64) *Guess {
+		g := &Guess{Server: utils.MailServer{Protocol: utils.MailIMAP, Security: security}, Works: works, Confidence: confidence}
+		if security != utils.SecurityPlain {
+			g.TLS = &TLSInspection{Verified: verified, HostnameMatch: hostnameMatch}
+		}
+		return g
+	}
+	validTLS := guess(utils.SecurityTLS, true, true, true, 0.9)
+	confidentTLS := guess(utils.SecurityTLS, true, true, true, 1)
+	wrongNameTLS := guess(utils.SecurityTLS, true, false, true, 0.7)
+	untrustedTLS := guess(utils.SecurityTLS, false, true, true, 0.6)
+	validSTARTTLS := guess(utils.SecuritySTARTTLS, true, true, true, 0.8)
+	plain := guess(utils.SecurityPlain, false, false, true, 0.5)
+	broken := guess(utils.SecurityTLS, true, true, false, 1)
+
+	tests := []struct {
+		name  string
+		tried []*Guess
+		want  *Guess
+	}{
+		{"a valid certificate before implicit TLS", []*Guess{wrongNameTLS, untrustedTLS, validSTARTTLS}, validSTARTTLS},
+		{"implicit TLS before STARTTLS", []*Guess{validSTARTTLS, validTLS}, validTLS},
+		{"TLS without a valid certificate before plain", []*Guess{plain, untrustedTLS}, untrustedTLS},
+		{"more confident", []*Guess{validTLS, confidentTLS}, confidentTLS},
+		{"ties keep the candidate order", []*Guess{wrongNameTLS, guess(utils.SecurityTLS, true, false, true, 0.7)}, wrongNameTLS},
+		{"only working guesses", []*Guess{broken, plain}, plain},
+		{"nothing works", []*Guess{broken}, nil},
+		{"nothing tried", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := bestGuess(test.tried); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// a STARTTLS server with a valid certificate wins over an implicit TLS one with a certificate for another name
+func TestGuessCertificateFirst(t *testing.T) {
+	ca, err := newTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := func(names ...string) *tls.Config {
+		cert, err := ca.Issue(time.Now().Add(time.Hour), names...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	implicit, err := startFakeServer(ProtocolIMAP, "ready", nil, config("imap.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer implicit.Close()
+	starttls, err := startFakeSTARTTLSServer(ProtocolIMAP, "ready", []string{"IMAP4rev1", "STARTTLS"}, config("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer starttls.Close()
+
+	g := testGuesser(ca)
+	tried := []*Guess{testGuess(implicit, "imap."), testGuess(starttls, "imap.")}
+	for _, guess := range tried {
+		g.try(guess)
+	}
+
+	if best := bestGuess(tried); best != tried[1] {
+		t.Errorf("best guess %+v", best)
+	}
+	if !tried[0].Works || tried[0].Server.Security != utils.SecurityTLS || tried[0].certificateUsable() {
+		t.Errorf("implicit TLS guess %+v", tried[0])
+	}
+}
@@ -0,0 +1,182 @@
+package probe
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RFC 9051 7.2.2 and RFC 3501 6.1.1
+func probeIMAP(conn net.Conn, r *bufio.Reader, result *Result) error {
+	greeting, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return fmt.Errorf("unexpected IMAP greeting: %q", greeting)
+	}
+	result.Banner = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(greeting, "* OK"), "* PREAUTH"))
+
+	if err := writeLine(conn, "a1 CAPABILITY"); err != nil {
+		return err
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "a1 ") {
+			if !strings.HasPrefix(line, "a1 OK") {
+				return fmt.Errorf("CAPABILITY failed: %q", line)
+			}
+			break
+		}
+		if rest, ok := cutPrefixFold(line, "* CAPABILITY "); ok {
+			result.Capabilities = strings.Fields(rest)
+		}
+	}
+
+	for _, c := range result.Capabilities {
+		upper := strings.ToUpper(c)
+		switch {
+		case upper == "STARTTLS":
+			result.STARTTLS = true
+		case upper == "LOGINDISABLED":
+			result.LoginDisabled = true
+		case strings.HasPrefix(upper, "AUTH="):
+			addMechanisms(result, []string{upper[len("AUTH="):]})
+		}
+	}
+
+	writeLine(conn, "a2 LOGOUT")
+	return nil
+}
+
+// RFC 1939 and RFC 2449 (CAPA)
+func probePOP3(conn net.Conn, r *bufio.Reader, result *Result) error {
+	greeting, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("unexpected POP3 greeting: %q", greeting)
+	}
+	result.Banner = strings.TrimSpace(strings.TrimPrefix(greeting, "+OK"))
+
+	if err := writeLine(conn, "CAPA"); err != nil {
+		return err
+	}
+	status, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(status, "+OK") {
+		// CAPA is optional, servers without it still work
+		writeLine(conn, "QUIT")
+		return nil
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if line == "." {
+			break
+		}
+		result.Capabilities = append(result.Capabilities, line)
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "STLS":
+			result.STARTTLS = true
+		case "SASL":
+			addMechanisms(result, fields[1:])
+		}
+	}
+
+	writeLine(conn, "QUIT")
+	return nil
+}
+
+// RFC 5321 4.1.1.1 and RFC 4954 (AUTH), RFC 3207 (STARTTLS)
+func probeSMTP(conn net.Conn, r *bufio.Reader, result *Result, ehloName string) error {
+	code, lines, err := readSMTPReply(r)
+	if err != nil {
+		return err
+	}
+	if code != "220" {
+		return fmt.Errorf("unexpected SMTP greeting: %v %v", code, strings.Join(lines, " "))
+	}
+	result.Banner = strings.Join(lines, " ")
+
+	if err := writeLine(conn, "EHLO "+ehloName); err != nil {
+		return err
+	}
+	code, lines, err = readSMTPReply(r)
+	if err != nil {
+		return err
+	}
+	if code != "250" {
+		return fmt.Errorf("EHLO failed: %v %v", code, strings.Join(lines, " "))
+	}
+
+	// the first line is the server's greeting to us, the rest are extensions
+	for _, line := range lines[1:] {
+		result.Capabilities = append(result.Capabilities, line)
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.ToUpper(fields[0])
+		switch {
+		case keyword == "STARTTLS":
+			result.STARTTLS = true
+		case keyword == "AUTH":
+			addMechanisms(result, fields[1:])
+		case strings.HasPrefix(keyword, "AUTH="):
+			// old Microsoft and Netscape servers
+			addMechanisms(result, append([]string{keyword[len("AUTH="):]}, fields[1:]...))
+		}
+	}
+
+	writeLine(conn, "QUIT")
+	return nil
+}
+
+// a possibly multi-line reply, "250-first", "250 last"
+func readSMTPReply(r *bufio.Reader) (string, []string, error) {
+	lines := make([]string, 0)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(line) < 3 {
+			return "", nil, fmt.Errorf("malformed SMTP reply: %q", line)
+		}
+		code := line[:3]
+		text := ""
+		if len(line) > 4 {
+			text = line[4:]
+		}
+		lines = append(lines, text)
+		if len(line) == 3 || line[3] == ' ' {
+			return code, lines, nil
+		}
+		if line[3] != '-' {
+			return "", nil, fmt.Errorf("malformed SMTP reply: %q", line)
+		}
+	}
+}
+
+func cutPrefixFold(s string, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
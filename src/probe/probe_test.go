@@ -0,0 +1,133 @@
+package probe
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djeidj/Analyzing-Email-services-autoconfigurations/utils"
+)
+
+// a server configuration with a certificate for 127.0.0.1 from a new test CA
+func testTLSConfig(t *testing.T, notAfter time.Time, names ...string) (*testCA, *tls.Config) {
+	t.Helper()
+	ca, err := newTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.Issue(notAfter, names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		protocol       Protocol
+		capabilities   []string
+		starttls       string // the capability that offers STARTTLS
+		wantMechanisms []string
+	}{
+		{ProtocolIMAP, []string{"IMAP4rev1", "AUTH=PLAIN", "AUTH=xoauth2"}, "STARTTLS", []string{"PLAIN", "XOAUTH2"}},
+		{ProtocolPOP3, []string{"USER", "SASL PLAIN LOGIN", "UIDL"}, "STLS", []string{"PLAIN", "LOGIN"}},
+		{ProtocolSMTP, []string{"8BITMIME", "AUTH PLAIN LOGIN", "AUTH=LOGIN", "SIZE 35882577"}, "STARTTLS", []string{"PLAIN", "LOGIN"}},
+	}
+
+	for _, test := range tests {
+		for _, implicitTLS := range []bool{true, false} {
+			name := string(test.protocol) + "/starttls"
+			if implicitTLS {
+				name = string(test.protocol) + "/tls"
+			}
+			t.Run(name, func(t *testing.T) {
+				ca, config := testTLSConfig(t, time.Now().Add(time.Hour), "127.0.0.1")
+
+				var s *fakeServer
+				var err error
+				capabilities := test.capabilities
+				if implicitTLS {
+					s, err = startFakeServer(test.protocol, "fake ready", capabilities, config)
+				} else {
+					capabilities = append(append([]string{}, capabilities...), test.starttls)
+					s, err = startFakeSTARTTLSServer(test.protocol, "fake ready", capabilities, config)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer s.Close()
+
+				p := New_Prober()
+				p.Timeout = 5 * time.Second
+				// the implicit TLS connection must verify against the test CA
+				p.TLSConfig = &tls.Config{RootCAs: ca.Pool()}
+
+				result := p.Probe(s.Endpoint())
+				if !result.Reachable || result.Error != "" {
+					t.Fatalf("probe failed: %+v", result)
+				}
+				if result.Banner != "fake ready" {
+					t.Errorf("banner %q", result.Banner)
+				}
+				if !reflect.DeepEqual(result.Capabilities, capabilities) {
+					t.Errorf("capabilities %q, want %q", result.Capabilities, capabilities)
+				}
+				if result.STARTTLS == implicitTLS {
+					t.Errorf("STARTTLS %v", result.STARTTLS)
+				}
+				if !reflect.DeepEqual(result.AuthMechanisms, test.wantMechanisms) {
+					t.Errorf("mechanisms %q, want %q", result.AuthMechanisms, test.wantMechanisms)
+				}
+			})
+		}
+	}
+}
+
+func TestProbeLoginDisabled(t *testing.T) {
+	s, err := startFakeServer(ProtocolIMAP, "ready", []string{"IMAP4rev1", "STARTTLS", "LOGINDISABLED"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	result := Probe_Endpoint(s.Endpoint())
+	if result.Error != "" || !result.STARTTLS || !result.LoginDisabled {
+		t.Errorf("result: %+v", result)
+	}
+}
+
+func TestProbeUnreachable(t *testing.T) {
+	s, err := startFakeServer(ProtocolSMTP, "ready", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.Endpoint()
+	s.Close()
+
+	result := Probe_Endpoint(e)
+	if result.Reachable || result.Error == "" {
+		t.Errorf("closed port reachable: %+v", result)
+	}
+}
+
+func TestEndpointFromSRV(t *testing.T) {
+	e, ok := Endpoint_FromSRV(utils.SRVTarget{Service: "_submissions._tcp", Target: "smtp.example.com", Port: 465})
+	if !ok || e != (Endpoint{Protocol: ProtocolSMTP, Host: "smtp.example.com", Port: 465, ImplicitTLS: true}) {
+		t.Errorf("submissions: %+v, %v", e, ok)
+	}
+	e, ok = Endpoint_FromSRV(utils.SRVTarget{Service: "_imap._tcp", Target: "imap.example.com", Port: 143})
+	if !ok || e.Protocol != ProtocolIMAP || e.ImplicitTLS {
+		t.Errorf("imap: %+v, %v", e, ok)
+	}
+
+	// RFC 2782, the service is not available
+	for _, target := range []string{".", ""} {
+		if _, ok := Endpoint_FromSRV(utils.SRVTarget{Service: "_imaps._tcp", Target: target}); ok {
+			t.Errorf("endpoint for target %q", target)
+		}
+	}
+	if _, ok := Endpoint_FromSRV(utils.SRVTarget{Service: "_autodiscover._tcp", Target: "autodiscover.example.com", Port: 443}); ok {
+		t.Error("endpoint for _autodiscover")
+	}
+}
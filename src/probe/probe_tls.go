@@ -0,0 +1,235 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// what an endpoint's TLS looks like to a client connecting to the advertised name
+type TLSInspection struct {
+	Endpoint          Endpoint
+	ServerName        string   // SNI and the name the certificate must match, the advertised host
+	STARTTLS          bool     // TLS was started with STARTTLS or STLS
+	Version           string   // negotiated, e.g. "TLS 1.3"
+	CipherSuite       string   // negotiated
+	SupportedVersions []string // versions the server accepts when offered alone, if checked
+	Chain             []CertificateInfo
+	Verified          bool // the chain leads to a root in the inspector's RootCAs
+	VerifyError       string
+	OnlyExpired       bool // the chain does verify within the leaf's validity period, expiry is the only problem
+	HostnameMatch     bool
+	HostnameError     string
+	Expired           bool     // the leaf is outside its validity period
+	SANs              []string // DNS names and IP addresses of the leaf
+	Error             string   // why no handshake happened
+}
+
+type CertificateInfo struct {
+	Subject            string
+	Issuer             string
+	SerialNumber       string // hex
+	NotBefore          time.Time
+	NotAfter           time.Time
+	DNSNames           []string
+	SignatureAlgorithm string
+	SelfSigned         bool
+}
+
+type Inspector struct {
+	Timeout       time.Duration
+	RootCAs       *x509.CertPool // nil for the system roots
+	EHLOName      string
+	CheckVersions bool // also try each TLS version alone, one connection per version
+}
+
+func New_Inspector() *Inspector {
+	return &Inspector{Timeout: 15 * time.Second, EHLOName: "localhost"}
+}
+
+func Inspect_TLS(e Endpoint) *TLSInspection {
+	return New_Inspector().Inspect(e)
+}
+
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// connect to e with implicit TLS or STARTTLS and inspect the certificate against e.Host
+func (i *Inspector) Inspect(e Endpoint) *TLSInspection {
+	result := &TLSInspection{Endpoint: e, ServerName: strings.TrimSuffix(e.Host, "."), STARTTLS: !e.ImplicitTLS}
+
+	state, err := i.handshake(e, result.ServerName, 0)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Version = tls.VersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	for _, cert := range state.PeerCertificates {
+		result.Chain = append(result.Chain, certificateInfo(cert))
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		result.SANs = append(result.SANs, leaf.DNSNames...)
+		for _, ip := range leaf.IPAddresses {
+			result.SANs = append(result.SANs, ip.String())
+		}
+		now := time.Now()
+		result.Expired = now.Before(leaf.NotBefore) || now.After(leaf.NotAfter)
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		options := x509.VerifyOptions{Roots: i.RootCAs, Intermediates: intermediates}
+		_, err := leaf.Verify(options)
+		result.Verified = err == nil
+		if err != nil {
+			result.VerifyError = err.Error()
+		}
+		if err != nil && result.Expired {
+			options.CurrentTime = leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+			_, err := leaf.Verify(options)
+			result.OnlyExpired = err == nil
+		}
+
+		err = leaf.VerifyHostname(result.ServerName)
+		result.HostnameMatch = err == nil
+		if err != nil {
+			result.HostnameError = err.Error()
+		}
+	}
+
+	if i.CheckVersions {
+		for _, v := range tlsVersions {
+			if _, err := i.handshake(e, result.ServerName, v); err == nil {
+				result.SupportedVersions = append(result.SupportedVersions, tls.VersionName(v))
+			}
+		}
+	}
+	return result
+}
+
+// one connection and handshake, only offering version if it isn't 0
+func (i *Inspector) handshake(e Endpoint, serverName string, version uint16) (*tls.ConnectionState, error) {
+	conn, err := net.DialTimeout("tcp", e.Address(), i.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(i.Timeout))
+
+	if !e.ImplicitTLS {
+		if err := startTLS(conn, e.Protocol, i.EHLOName); err != nil {
+			return nil, err
+		}
+	}
+
+	// verification is done afterwards to record why it fails
+	config := &tls.Config{ServerName: serverName, InsecureSkipVerify: true, MinVersion: tls.VersionTLS10}
+	if version != 0 {
+		config.MinVersion, config.MaxVersion = version, version
+	}
+	client := tls.Client(conn, config)
+	if err := client.Handshake(); err != nil {
+		return nil, err
+	}
+	state := client.ConnectionState()
+	return &state, nil
+}
+
+// ask a plain text server to start TLS. Reads are byte by byte so nothing of the handshake is buffered away.
+func startTLS(conn net.Conn, protocol Protocol, ehloName string) error {
+	r := bufio.NewReaderSize(&byteReader{conn}, 16)
+
+	switch protocol {
+	case ProtocolIMAP:
+		if _, err := readLine(r); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "a1 STARTTLS"); err != nil {
+			return err
+		}
+		for {
+			line, err := readLine(r)
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a1 ") {
+				if !strings.HasPrefix(line, "a1 OK") {
+					return fmt.Errorf("STARTTLS refused: %q", line)
+				}
+				return nil
+			}
+		}
+	case ProtocolPOP3:
+		if _, err := readLine(r); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "STLS"); err != nil {
+			return err
+		}
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("STLS refused: %q", line)
+		}
+		return nil
+	case ProtocolSMTP:
+		if _, _, err := readSMTPReply(r); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "EHLO "+ehloName); err != nil {
+			return err
+		}
+		if _, _, err := readSMTPReply(r); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "STARTTLS"); err != nil {
+			return err
+		}
+		code, lines, err := readSMTPReply(r)
+		if err != nil {
+			return err
+		}
+		if code != "220" {
+			return fmt.Errorf("STARTTLS refused: %v %v", code, strings.Join(lines, " "))
+		}
+		return nil
+	}
+	return fmt.Errorf("no STARTTLS for %v", protocol)
+}
+
+// an io.Reader that reads one byte at a time
+type byteReader struct {
+	conn net.Conn
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return b.conn.Read(p[:1])
+}
+
+func certificateInfo(cert *x509.Certificate) CertificateInfo {
+	return CertificateInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       hex.EncodeToString(cert.SerialNumber.Bytes()),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DNSNames:           cert.DNSNames,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SelfSigned:         bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil,
+	}
+}
@@ -0,0 +1,120 @@
+package probe
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		name          string
+		protocol      Protocol
+		implicitTLS   bool
+		notAfter      time.Duration // from now
+		names         []string
+		verified      bool
+		hostnameMatch bool
+		expired       bool
+	}{
+		{"valid", ProtocolIMAP, true, time.Hour, []string{"127.0.0.1"}, true, true, false},
+		{"valid starttls", ProtocolSMTP, false, time.Hour, []string{"127.0.0.1"}, true, true, false},
+		{"expired", ProtocolPOP3, false, -time.Hour, []string{"127.0.0.1"}, false, true, true},
+		{"wrong name", ProtocolSMTP, true, time.Hour, []string{"mail.example.com"}, true, false, false},
+		{"wrong name starttls", ProtocolIMAP, false, time.Hour, []string{"mail.example.com"}, true, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ca, config := testTLSConfig(t, time.Now().Add(test.notAfter), test.names...)
+			config.MinVersion = tls.VersionTLS12
+
+			var s *fakeServer
+			var err error
+			if test.implicitTLS {
+				s, err = startFakeServer(test.protocol, "ready", nil, config)
+			} else {
+				s, err = startFakeSTARTTLSServer(test.protocol, "ready", nil, config)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			i := New_Inspector()
+			i.Timeout = 5 * time.Second
+			i.RootCAs = ca.Pool()
+			i.CheckVersions = true
+
+			result := i.Inspect(s.Endpoint())
+			if result.Error != "" {
+				t.Fatalf("inspection failed: %v", result.Error)
+			}
+			if result.STARTTLS == test.implicitTLS {
+				t.Errorf("STARTTLS %v", result.STARTTLS)
+			}
+			if result.Verified != test.verified || result.Expired != test.expired || result.HostnameMatch != test.hostnameMatch {
+				t.Errorf("verified %v (%v), expired %v, hostname match %v (%v)", result.Verified, result.VerifyError, result.Expired, result.HostnameMatch, result.HostnameError)
+			}
+			if result.OnlyExpired != test.expired {
+				t.Errorf("only expired %v", result.OnlyExpired)
+			}
+			if !result.Verified && result.VerifyError == "" || !result.HostnameMatch && result.HostnameError == "" {
+				t.Errorf("failure without a reason: %+v", result)
+			}
+			if !reflect.DeepEqual(result.SANs, test.names) {
+				t.Errorf("SANs %q", result.SANs)
+			}
+			if len(result.Chain) != 2 || !result.Chain[1].SelfSigned || result.Chain[0].SelfSigned {
+				t.Errorf("chain: %+v", result.Chain)
+			}
+			if result.Version != "TLS 1.3" {
+				t.Errorf("version %v", result.Version)
+			}
+			if want := []string{"TLS 1.2", "TLS 1.3"}; !reflect.DeepEqual(result.SupportedVersions, want) {
+				t.Errorf("supported versions %q, want %q", result.SupportedVersions, want)
+			}
+		})
+	}
+}
+
+func TestInspectUntrustedCA(t *testing.T) {
+	_, config := testTLSConfig(t, time.Now().Add(time.Hour), "127.0.0.1")
+	s, err := startFakeServer(ProtocolIMAP, "ready", nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	other, err := newTestCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := New_Inspector()
+	i.Timeout = 5 * time.Second
+	i.RootCAs = other.Pool()
+
+	result := i.Inspect(s.Endpoint())
+	if result.Error != "" || result.Verified || result.VerifyError == "" || !result.HostnameMatch || result.Expired || result.OnlyExpired {
+		t.Errorf("result: %+v", result)
+	}
+	if result.SupportedVersions != nil {
+		t.Errorf("versions checked without CheckVersions: %q", result.SupportedVersions)
+	}
+}
+
+func TestInspectSTARTTLSRefused(t *testing.T) {
+	s, err := startFakeSTARTTLSServer(ProtocolPOP3, "ready", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	i := New_Inspector()
+	i.Timeout = 5 * time.Second
+	result := i.Inspect(s.Endpoint())
+	if result.Error == "" || result.Version != "" {
+		t.Errorf("result: %+v", result)
+	}
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// an email address split into its parts, see RFC 5322 3.4.1 and RFC 6531 3.3
+type EmailAddress struct {
+	LocalPart   string // as written, quoted local parts keep their quotes
+	Domain      string // U-label form, e.g. "bücher.example"
+	ASCIIDomain string // A-label form used in DNS names and URLs, e.g. "xn--bcher-kva.example"
+}
+
+// local parts may contain UTF-8 (RFC 6531), every other character must be atext or "."
+const atextSpecials = "!#$%&'*+-/=?^_`{|}~"
+
+// parse an addr-spec like "user+tag@bücher.example", display names and comments are not accepted
+func Parse_EmailAddress(email_address string) (*EmailAddress, error) {
+	email_address = strings.TrimSpace(email_address)
+	if !utf8.ValidString(email_address) {
+		return nil, fmt.Errorf("invalid email address: %q is not UTF-8", email_address)
+	}
+
+	at := strings.LastIndex(email_address, "@")
+	if at <= 0 || at == len(email_address)-1 {
+		return nil, fmt.Errorf("invalid email address: %v", email_address)
+	}
+
+	local := email_address[:at]
+	domain := email_address[at+1:]
+
+	if err := checkLocalPart(local); err != nil {
+		return nil, fmt.Errorf("invalid email address: %v: %v", email_address, err)
+	}
+
+	if strings.HasPrefix(domain, "[") {
+		return nil, fmt.Errorf("invalid email address: %v: domain literals are not supported", email_address)
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address: %v: %v", email_address, err)
+	}
+	if !strings.Contains(ascii, ".") || len(ascii) > 253 {
+		return nil, fmt.Errorf("invalid email address: %v: invalid domain", email_address)
+	}
+
+	unicode, err := idna.Lookup.ToUnicode(ascii)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address: %v: %v", email_address, err)
+	}
+
+	return &EmailAddress{LocalPart: local, Domain: unicode, ASCIIDomain: ascii}, nil
+}
+
+func checkLocalPart(local string) error {
+	if len(local) > 64 {
+		return fmt.Errorf("local part longer than 64 octets")
+	}
+
+	// quoted-string
+	if strings.HasPrefix(local, `"`) {
+		if len(local) < 2 || !strings.HasSuffix(local, `"`) {
+			return fmt.Errorf("unterminated quoted local part")
+		}
+		inner := local[1 : len(local)-1]
+		for i := 0; i < len(inner); i++ {
+			switch inner[i] {
+			case '\\':
+				i++
+				if i == len(inner) {
+					return fmt.Errorf("unterminated quoted pair in local part")
+				}
+			case '"', '\r', '\n':
+				return fmt.Errorf("invalid character in quoted local part")
+			}
+		}
+		return nil
+	}
+
+	// dot-atom
+	if strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, "..") {
+		return fmt.Errorf("misplaced dot in local part")
+	}
+	for _, r := range local {
+		switch {
+		case r >= 0x80: // UTF8-non-ascii, RFC 6531
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.':
+		case strings.ContainsRune(atextSpecials, r):
+		default:
+			return fmt.Errorf("invalid character %q in local part", r)
+		}
+	}
+	return nil
+}
+
+// the address with the U-label domain, as the user would type it
+func (a *EmailAddress) String() string {
+	return a.LocalPart + "@" + a.Domain
+}
+
+// the address with the A-label domain, used in requests to servers that are not SMTPUTF8 aware
+func (a *EmailAddress) ASCII() string {
+	return a.LocalPart + "@" + a.ASCIIDomain
+}
+
+// whether the local part contains non-ASCII characters, such an address needs SMTPUTF8 (RFC 6531)
+func (a *EmailAddress) IsInternationalized() bool {
+	for i := 0; i < len(a.LocalPart); i++ {
+		if a.LocalPart[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// a name for files that belong to this address, path separators are escaped
+func (a *EmailAddress) FileName() string {
+	return strings.NewReplacer("/", "%2F", `\`, "%5C").Replace(a.String())
+}
+
+// build an URL, host must be in A-label form, query values are escaped
+func Build_URL(scheme string, host string, path string, query url.Values) string {
+	u := url.URL{
+		Scheme: scheme,
+		Host:   host,
+		Path:   path,
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// convert a host name to A-label form for DNS lookups, a trailing dot is removed
+func To_ASCIIHost(host string) (string, error) {
+	return idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,824 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the outcome of validating an answer, RFC 4033 5
+type DNSSECStatus string
+
+const (
+	DNSSECSecure        DNSSECStatus = "secure"        // signed, with a chain of signatures up to a trust anchor
+	DNSSECInsecure      DNSSECStatus = "insecure"      // a zone on the way is provably unsigned
+	DNSSECBogus         DNSSECStatus = "bogus"         // signatures are missing, expired or wrong where there should be some
+	DNSSECIndeterminate DNSSECStatus = "indeterminate" // validation was not possible, e.g. the resolver failed or there is no trust anchor
+)
+
+// the root zone KSKs, KSK-2017 and KSK-2024
+var RootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// how one lookup validated
+type DNSSECResult struct {
+	Name   string
+	Type   string
+	Status DNSSECStatus
+	Reason string // why the answer is not secure
+}
+
+// a DNS resolver that validates answers itself. It asks Server with the DO and CD bits set,
+// so the server must be a recursive resolver that passes signatures through.
+type Resolver struct {
+	Server       string   // "host:port"
+	TrustAnchors []dns.RR // DS or DNSKEY records, any zone can be an anchor
+	Timeout      time.Duration
+
+	mu    sync.Mutex
+	zones map[string]*zoneKeys // validated keys per zone, or why there are none. Only finished results are stored.
+}
+
+type zoneKeys struct {
+	keys   []*dns.DNSKEY
+	status DNSSECStatus
+	reason string
+}
+
+// a resolver asking server, or the first nameserver of /etc/resolv.conf if server is "", anchored at the root
+func New_Resolver(server string) (*Resolver, error) {
+	if server == "" {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		if len(conf.Servers) == 0 {
+			return nil, fmt.Errorf("no nameserver in /etc/resolv.conf")
+		}
+		server = net.JoinHostPort(conf.Servers[0], conf.Port)
+	} else if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	anchors, err := Parse_TrustAnchors(RootTrustAnchors)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{Server: server, TrustAnchors: anchors, Timeout: 5 * time.Second}, nil
+}
+
+// parse DS or DNSKEY records in zone file format
+func Parse_TrustAnchors(anchors []string) ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(anchors))
+	for _, a := range anchors {
+		rr, err := dns.NewRR(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor %q: %v", a, err)
+		}
+		switch rr.(type) {
+		case *dns.DS, *dns.DNSKEY:
+		default:
+			return nil, fmt.Errorf("invalid trust anchor %q: not a DS or DNSKEY record", a)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+var (
+	defaultResolver    *Resolver
+	defaultResolverErr error
+	defaultResolverSet sync.Once
+)
+
+// the resolver used by `Lookup_SRV` and `Lookup_MX`, see `New_Resolver`
+func Default_Resolver() (*Resolver, error) {
+	defaultResolverSet.Do(func() {
+		defaultResolver, defaultResolverErr = New_Resolver("")
+	})
+	return defaultResolver, defaultResolverErr
+}
+
+// look up SRV records with `Default_Resolver`, falling back to the stub resolver when it fails
+func Lookup_SRV(service string, proto string, name string) ([]*net.SRV, DNSSECResult, error) {
+	r, err := Default_Resolver()
+	if err != nil {
+		_, srvs, err := net.LookupSRV(service, proto, name)
+		return srvs, DNSSECResult{Name: name, Type: "SRV", Status: DNSSECIndeterminate, Reason: "no resolver"}, err
+	}
+	srvs, result, err := r.Lookup_SRV(service, proto, name)
+	if err != nil && result.Status == DNSSECIndeterminate {
+		_, srvs, err = net.LookupSRV(service, proto, name)
+	}
+	return srvs, result, err
+}
+
+// look up MX records with `Default_Resolver`, falling back to the stub resolver when it fails
+func Lookup_MX(name string) ([]*net.MX, DNSSECResult, error) {
+	r, err := Default_Resolver()
+	if err != nil {
+		mxs, err := net.LookupMX(name)
+		return mxs, DNSSECResult{Name: name, Type: "MX", Status: DNSSECIndeterminate, Reason: "no resolver"}, err
+	}
+	mxs, result, err := r.Lookup_MX(name)
+	if err != nil && result.Status == DNSSECIndeterminate {
+		mxs, err = net.LookupMX(name)
+	}
+	return mxs, result, err
+}
+
+// _service._proto.name SRV, ordered by priority, then weight. The "." target (RFC 2782, service not available) is kept.
+func (r *Resolver) Lookup_SRV(service string, proto string, name string) ([]*net.SRV, DNSSECResult, error) {
+	qname := "_" + service + "._" + proto + "." + dns.Fqdn(name)
+	rrs, result, err := r.lookup(qname, dns.TypeSRV)
+	if err != nil {
+		return nil, result, err
+	}
+
+	srvs := make([]*net.SRV, 0, len(rrs))
+	for _, rr := range rrs {
+		s := rr.(*dns.SRV)
+		srvs = append(srvs, &net.SRV{Target: s.Target, Port: s.Port, Priority: s.Priority, Weight: s.Weight})
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight > srvs[j].Weight
+	})
+	if len(srvs) == 0 {
+		return nil, result, fmt.Errorf("no SRV records for %v", qname)
+	}
+	return srvs, result, nil
+}
+
+// MX records ordered by preference
+func (r *Resolver) Lookup_MX(name string) ([]*net.MX, DNSSECResult, error) {
+	rrs, result, err := r.lookup(dns.Fqdn(name), dns.TypeMX)
+	if err != nil {
+		return nil, result, err
+	}
+
+	mxs := make([]*net.MX, 0, len(rrs))
+	for _, rr := range rrs {
+		m := rr.(*dns.MX)
+		mxs = append(mxs, &net.MX{Host: m.Mx, Pref: m.Preference})
+	}
+	sort.SliceStable(mxs, func(i, j int) bool {
+		return mxs[i].Pref < mxs[j].Pref
+	})
+	if len(mxs) == 0 {
+		return nil, result, fmt.Errorf("no MX records for %v", name)
+	}
+	return mxs, result, nil
+}
+
+// the records of qtype for qname, following CNAMEs, and how the whole answer validated
+func (r *Resolver) lookup(qname string, qtype uint16) ([]dns.RR, DNSSECResult, error) {
+	result := DNSSECResult{Name: qname, Type: dns.TypeToString[qtype], Status: DNSSECIndeterminate}
+
+	resp, err := r.exchange(qname, qtype)
+	if err != nil {
+		result.Reason = err.Error()
+		return nil, result, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		err = fmt.Errorf("lookup %v: %v", qname, dns.RcodeToString[resp.Rcode])
+		result.Reason = err.Error()
+		return nil, result, err
+	}
+
+	// only the records of qname and of the names its CNAMEs point to answer the question,
+	// anything else is dropped however well it is signed
+	answer := answerFor(qname, resp.Answer)
+	records := make([]dns.RR, 0)
+	for _, rr := range answer {
+		if rr.Header().Rrtype == qtype {
+			records = append(records, rr)
+		}
+	}
+
+	// the answer is as secure as its least secure RRset, an empty answer as its denial
+	section := answer
+	if len(records) == 0 {
+		section = append(append([]dns.RR{}, answer...), resp.Ns...)
+	}
+	visiting := make(map[string]bool)
+	result.Status, result.Reason = DNSSECSecure, ""
+	sets, sigs := groupRRsets(section)
+	if len(sets) == 0 {
+		result.Status, result.Reason = r.unsigned(qname, visiting)
+	}
+	for key, set := range sets {
+		status, reason := r.validate(set, sigs[key], resp.Ns, visiting)
+		if worse(status, result.Status) {
+			result.Status, result.Reason = status, reason
+		}
+	}
+
+	// signed NSEC or NSEC3 records are not enough, they have to deny the name that was asked for
+	if len(records) == 0 && result.Status == DNSSECSecure {
+		result.Status, result.Reason = proveDenial(cnameTarget(qname, answer), qtype, sets, resp.Rcode == dns.RcodeNameError)
+	}
+	return records, result, nil
+}
+
+// the records of answer owned by qname or by a name in the CNAME chain starting at it, with their signatures
+func answerFor(qname string, answer []dns.RR) []dns.RR {
+	name := dns.CanonicalName(qname)
+	owners := map[string]bool{name: true}
+	for i := 0; i < len(answer); i++ {
+		for _, rr := range answer {
+			if c, ok := rr.(*dns.CNAME); ok && dns.CanonicalName(c.Hdr.Name) == name {
+				name = dns.CanonicalName(c.Target)
+				owners[name] = true
+				break
+			}
+		}
+	}
+
+	records := make([]dns.RR, 0, len(answer))
+	for _, rr := range answer {
+		if owners[dns.CanonicalName(rr.Header().Name)] {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// the end of the CNAME chain starting at qname, qname if there is none
+func cnameTarget(qname string, answer []dns.RR) string {
+	name := dns.CanonicalName(qname)
+	for i := 0; i < len(answer); i++ {
+		for _, rr := range answer {
+			if c, ok := rr.(*dns.CNAME); ok && dns.CanonicalName(c.Hdr.Name) == name {
+				name = dns.CanonicalName(c.Target)
+				break
+			}
+		}
+	}
+	return name
+}
+
+func (r *Resolver) exchange(qname string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(qname), qtype)
+	m.SetEdns0(4096, true)
+	m.CheckingDisabled = true
+
+	c := &dns.Client{Timeout: r.Timeout}
+	resp, _, err := c.Exchange(m, r.Server)
+	if err == nil && resp.Truncated {
+		c.Net = "tcp"
+		resp, _, err = c.Exchange(m, r.Server)
+	}
+	return resp, err
+}
+
+// validate one RRset with its signatures. visiting holds the zones whose keys are being looked for on the way here.
+// An RRset expanded from a wildcard needs the proof from the NSEC or NSEC3 records in authority, nil where there can't be one.
+func (r *Resolver) validate(set []dns.RR, sigs []*dns.RRSIG, authority []dns.RR, visiting map[string]bool) (DNSSECStatus, string) {
+	owner := dns.CanonicalName(set[0].Header().Name)
+	if len(sigs) == 0 {
+		return r.unsigned(owner, visiting)
+	}
+
+	// RFC 4034 3.1.3, the labels of the owner without a leading wildcard
+	labels := dns.CountLabel(owner)
+	if strings.HasPrefix(owner, "*.") {
+		labels--
+	}
+
+	reason := ""
+	for _, sig := range sigs {
+		signer := dns.CanonicalName(sig.SignerName)
+		// a DS RRset is signed by the parent, everything else by its own zone or an ancestor
+		if !dns.IsSubDomain(signer, owner) || (set[0].Header().Rrtype == dns.TypeDS && signer == owner) {
+			reason = fmt.Sprintf("%v is signed by %v, which is not its zone", owner, signer)
+			continue
+		}
+		if int(sig.Labels) > labels {
+			reason = fmt.Sprintf("the signature of %v has more labels than %v", owner, owner)
+			continue
+		}
+
+		zone := r.keysOf(signer, visiting)
+		if zone.status != DNSSECSecure {
+			return zone.status, zone.reason
+		}
+		if err := verify(set, sig, zone.keys); err != nil {
+			reason = fmt.Sprintf("%v %v: %v", owner, dns.TypeToString[set[0].Header().Rrtype], err)
+			continue
+		}
+		// fewer labels than the owner: the signature is over the wildcard the RRset was expanded from
+		if int(sig.Labels) < labels {
+			return r.proveExpansion(owner, int(sig.Labels), authority, visiting)
+		}
+		return DNSSECSecure, ""
+	}
+	return DNSSECBogus, reason
+}
+
+// RFC 4035 5.3.4 and RFC 5155 8.8, an RRset expanded from a wildcard with labels labels
+// is only secure if the next closer name, one label below the wildcard's parent towards owner, doesn't exist
+func (r *Resolver) proveExpansion(owner string, labels int, authority []dns.RR, visiting map[string]bool) (DNSSECStatus, string) {
+	split := dns.Split(owner)
+	nextCloser := owner[split[len(split)-labels-1]:]
+
+	sets, sigs := groupRRsets(authority)
+	for key, set := range sets {
+		for _, rr := range set {
+			proves := false
+			switch n := rr.(type) {
+			case *dns.NSEC:
+				proves = covers(n, nextCloser) && !aboveCut(n, nextCloser)
+			case *dns.NSEC3:
+				proves = n.Hash == dns.SHA1 && n.Cover(nextCloser) && !n.Match(nextCloser)
+			}
+			if proves {
+				return r.validate(set, sigs[key], nil, visiting)
+			}
+		}
+	}
+	return DNSSECBogus, fmt.Sprintf("%v is expanded from a wildcard without proof that %v doesn't exist", owner, nextCloser)
+}
+
+// an RRset without signatures is insecure if its zone is provably unsigned, and bogus in a signed zone
+func (r *Resolver) unsigned(owner string, visiting map[string]bool) (DNSSECStatus, string) {
+	zone := r.keysOf(owner, visiting)
+	if zone.status == DNSSECSecure {
+		return DNSSECBogus, fmt.Sprintf("%v has no signatures in a signed zone", owner)
+	}
+	return zone.status, zone.reason
+}
+
+// the validated keys of the zone name belongs to. name need not be a zone apex,
+// if it isn't the keys of the enclosing zone are returned.
+// visiting[zone] is set once a search ran into a loop back to zone, the results found until zone is done depend on it.
+func (r *Resolver) keysOf(name string, visiting map[string]bool) *zoneKeys {
+	name = dns.CanonicalName(name)
+
+	r.mu.Lock()
+	z, ok := r.zones[name]
+	r.mu.Unlock()
+	if ok {
+		return z
+	}
+	// loops through broken delegations are only seen by the lookup running into them,
+	// concurrent lookups of the same zone each find the keys themselves
+	if _, ok := visiting[name]; ok {
+		visiting[name] = true
+		return &zoneKeys{status: DNSSECIndeterminate, reason: "validation loop at " + name}
+	}
+	visiting[name] = false
+	z = r.findKeys(name, visiting)
+	delete(visiting, name)
+
+	// a result that ran into a zone still being looked for is only valid on this path
+	for _, loop := range visiting {
+		if loop {
+			return z
+		}
+	}
+
+	r.mu.Lock()
+	if r.zones == nil {
+		r.zones = make(map[string]*zoneKeys)
+	}
+	r.zones[name] = z
+	r.mu.Unlock()
+	return z
+}
+
+func (r *Resolver) findKeys(name string, visiting map[string]bool) *zoneKeys {
+	anchors := make([]dns.RR, 0)
+	for _, a := range r.TrustAnchors {
+		if dns.CanonicalName(a.Header().Name) == name {
+			anchors = append(anchors, a)
+		}
+	}
+	if len(anchors) > 0 {
+		return r.zoneKeysFrom(name, anchors, true)
+	}
+	if name == "." {
+		return &zoneKeys{status: DNSSECIndeterminate, reason: "no trust anchor"}
+	}
+
+	resp, err := r.exchange(name, dns.TypeDS)
+	if err != nil {
+		return &zoneKeys{status: DNSSECIndeterminate, reason: err.Error()}
+	}
+
+	ds := make([]dns.RR, 0)
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeDS && dns.CanonicalName(rr.Header().Name) == name {
+			ds = append(ds, rr)
+		}
+	}
+	if len(ds) > 0 {
+		sets, sigs := groupRRsets(resp.Answer)
+		key := rrsetKey{name, dns.TypeDS}
+		if status, reason := r.validate(sets[key], sigs[key], nil, visiting); status != DNSSECSecure {
+			return &zoneKeys{status: status, reason: reason}
+		}
+		return r.zoneKeysFrom(name, ds, false)
+	}
+
+	// no DS: name is inside its parent's zone, or an unsigned delegation. Either needs proof from the parent.
+	parent := parentName(name)
+	sets, sigs := groupRRsets(resp.Ns)
+	proved, delegation := false, false
+	for key, set := range sets {
+		if key.rrtype != dns.TypeNSEC && key.rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		status, reason := r.validate(set, sigs[key], nil, visiting)
+		if status != DNSSECSecure {
+			return &zoneKeys{status: status, reason: reason}
+		}
+		p, d := proveNoDS(name, set, resp.Rcode == dns.RcodeNameError)
+		proved = proved || p
+		delegation = delegation || d
+	}
+
+	if proved && delegation {
+		return &zoneKeys{status: DNSSECInsecure, reason: fmt.Sprintf("%v is an unsigned delegation", name)}
+	}
+	if proved {
+		return r.keysOf(parent, visiting)
+	}
+
+	// without NSEC records the parent zone has to be unsigned
+	z := r.keysOf(parent, visiting)
+	if z.status == DNSSECSecure {
+		return &zoneKeys{status: DNSSECBogus, reason: fmt.Sprintf("no DS for %v and no proof of its absence", name)}
+	}
+	return z
+}
+
+// fetch the DNSKEYs of zone and keep them if one of them matches a DS or DNSKEY in trusted and signs the rest.
+// trusted are the configured trust anchors if anchored is set.
+func (r *Resolver) zoneKeysFrom(zone string, trusted []dns.RR, anchored bool) *zoneKeys {
+	resp, err := r.exchange(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return &zoneKeys{status: DNSSECIndeterminate, reason: err.Error()}
+	}
+
+	sets, sigs := groupRRsets(resp.Answer)
+	key := rrsetKey{zone, dns.TypeDNSKEY}
+	set := sets[key]
+	if len(set) == 0 && anchored {
+		// an anchor zone is known to be signed, the resolver strips the keys
+		return &zoneKeys{status: DNSSECIndeterminate, reason: fmt.Sprintf("resolver returned no DNSKEY for %v", zone)}
+	}
+	if len(set) == 0 {
+		return &zoneKeys{status: DNSSECBogus, reason: fmt.Sprintf("no DNSKEY for %v", zone)}
+	}
+
+	// RFC 4034 2.1.1 and RFC 5011 7, only zone keys that aren't revoked sign anything.
+	// The RRset is still verified as a whole.
+	keys := make([]*dns.DNSKEY, 0, len(set))
+	for _, rr := range set {
+		k := rr.(*dns.DNSKEY)
+		if k.Flags&dns.ZONE != 0 && k.Flags&dns.REVOKE == 0 {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return &zoneKeys{status: DNSSECBogus, reason: fmt.Sprintf("no DNSKEY of %v is a zone key that isn't revoked", zone)}
+	}
+
+	entry := make([]*dns.DNSKEY, 0)
+	for _, k := range keys {
+		for _, t := range trusted {
+			if matchesAnchor(k, t) {
+				entry = append(entry, k)
+			}
+		}
+	}
+	if len(entry) == 0 {
+		return &zoneKeys{status: DNSSECBogus, reason: fmt.Sprintf("no DNSKEY of %v matches its DS", zone)}
+	}
+
+	for _, sig := range sigs[key] {
+		if verify(set, sig, entry) == nil {
+			return &zoneKeys{keys: keys, status: DNSSECSecure}
+		}
+	}
+	return &zoneKeys{status: DNSSECBogus, reason: fmt.Sprintf("DNSKEY RRset of %v is not signed by a trusted key", zone)}
+}
+
+func matchesAnchor(key *dns.DNSKEY, anchor dns.RR) bool {
+	switch a := anchor.(type) {
+	case *dns.DS:
+		ds := key.ToDS(a.DigestType)
+		return ds != nil && ds.KeyTag == a.KeyTag && ds.Algorithm == a.Algorithm && strings.EqualFold(ds.Digest, a.Digest)
+	case *dns.DNSKEY:
+		return key.Algorithm == a.Algorithm && key.Flags == a.Flags && key.PublicKey == a.PublicKey
+	}
+	return false
+}
+
+// verify sig over set with one of keys, including its validity period
+func verify(set []dns.RR, sig *dns.RRSIG, keys []*dns.DNSKEY) error {
+	if !sig.ValidityPeriod(time.Now()) {
+		return fmt.Errorf("signature expired or not yet valid")
+	}
+	err := fmt.Errorf("no key with tag %v", sig.KeyTag)
+	for _, k := range keys {
+		if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err = sig.Verify(k, set); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// whether the NSEC or NSEC3 records in set prove there is no DS at name, and whether name is a delegation.
+// RFC 4035 5.2 and RFC 5155 8.9, an opt-out span counts as an unsigned delegation.
+func proveNoDS(name string, set []dns.RR, nxdomain bool) (bool, bool) {
+	for _, rr := range set {
+		switch n := rr.(type) {
+		case *dns.NSEC:
+			if dns.CanonicalName(n.Hdr.Name) == name {
+				return !hasType(n.TypeBitMap, dns.TypeDS), hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA)
+			}
+			if nxdomain && covers(n, name) {
+				return true, false
+			}
+		case *dns.NSEC3:
+			if n.Match(name) {
+				return !hasType(n.TypeBitMap, dns.TypeDS), hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA)
+			}
+			if n.Cover(name) {
+				optOut := n.Flags&1 == 1
+				return true, optOut
+			}
+		}
+	}
+	return false, false
+}
+
+// whether the validated NSEC or NSEC3 records of an empty answer prove that qname doesn't exist (nxdomain),
+// or has no qtype records. RFC 4035 5.4 and RFC 5155 8.
+func proveDenial(qname string, qtype uint16, sets map[rrsetKey][]dns.RR, nxdomain bool) (DNSSECStatus, string) {
+	nsecs, nsec3s := make([]*dns.NSEC, 0), make([]*dns.NSEC3, 0)
+	for _, set := range sets {
+		for _, rr := range set {
+			switch n := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, n)
+			case *dns.NSEC3:
+				// RFC 5155 8.1, only SHA-1 is defined
+				if n.Hash == dns.SHA1 {
+					nsec3s = append(nsec3s, n)
+				}
+			}
+		}
+	}
+
+	denial := "no data"
+	if nxdomain {
+		denial = "nonexistence"
+	}
+	switch {
+	case len(nsecs) > 0 && proveDenialNSEC(qname, qtype, nsecs, nxdomain):
+		return DNSSECSecure, ""
+	case len(nsec3s) > 0:
+		return proveDenialNSEC3(qname, qtype, nsec3s, nxdomain)
+	}
+	return DNSSECBogus, fmt.Sprintf("no proof of %v for %v %v", denial, qname, dns.TypeToString[qtype])
+}
+
+// RFC 4035 3.1.3 and 5.4
+func proveDenialNSEC(qname string, qtype uint16, nsecs []*dns.NSEC, nxdomain bool) bool {
+	if !nxdomain {
+		// the NSEC of qname lists neither qtype nor a CNAME
+		for _, n := range nsecs {
+			if dns.CanonicalName(n.Hdr.Name) != qname {
+				continue
+			}
+			// the parent side of a delegation only speaks for the DS
+			if qtype != dns.TypeDS && isDelegation(n.TypeBitMap) {
+				return false
+			}
+			return !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, dns.TypeCNAME)
+		}
+	}
+
+	for _, n := range nsecs {
+		if !covers(n, qname) || aboveCut(n, qname) {
+			continue
+		}
+		// a next name below qname makes qname an empty non-terminal: it exists, without any data
+		if next := dns.CanonicalName(n.NextDomain); next != qname && dns.IsSubDomain(qname, next) {
+			if !nxdomain {
+				return true
+			}
+			continue
+		}
+		wildcard := "*." + closestEncloser(n, qname)
+		if wildcard == "*.." {
+			wildcard = "*."
+		}
+		for _, w := range nsecs {
+			if nxdomain && covers(w, wildcard) && !aboveCut(w, wildcard) {
+				return true
+			}
+			// a wildcard that exists, but not with qtype
+			if !nxdomain && dns.CanonicalName(w.Hdr.Name) == wildcard && !hasType(w.TypeBitMap, qtype) && !hasType(w.TypeBitMap, dns.TypeCNAME) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RFC 5155 8.4 - 8.7
+func proveDenialNSEC3(qname string, qtype uint16, nsec3s []*dns.NSEC3, nxdomain bool) (DNSSECStatus, string) {
+	if !nxdomain {
+		for _, n := range nsec3s {
+			if !n.Match(qname) {
+				continue
+			}
+			switch {
+			case hasType(n.TypeBitMap, qtype) || hasType(n.TypeBitMap, dns.TypeCNAME):
+				return DNSSECBogus, fmt.Sprintf("the NSEC3 of %v lists %v", qname, dns.TypeToString[qtype])
+			case qtype != dns.TypeDS && isDelegation(n.TypeBitMap):
+				return DNSSECBogus, fmt.Sprintf("the NSEC3 of %v is from the parent side of a delegation", qname)
+			}
+			return DNSSECSecure, ""
+		}
+	}
+
+	encloser, nextCloser, optOut, ok := closestEncloserProof(qname, nsec3s)
+	if !ok {
+		return DNSSECBogus, fmt.Sprintf("no NSEC3 closest encloser proof for %v", qname)
+	}
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+
+	if nxdomain {
+		if !coveredNSEC3(wildcard, nsec3s) {
+			return DNSSECBogus, fmt.Sprintf("no NSEC3 proof that %v doesn't exist", wildcard)
+		}
+		if optOut {
+			return DNSSECInsecure, fmt.Sprintf("%v is in an NSEC3 opt-out span", nextCloser)
+		}
+		return DNSSECSecure, ""
+	}
+
+	// no DS for an unsigned delegation in an opt-out span
+	if qtype == dns.TypeDS && optOut {
+		return DNSSECInsecure, fmt.Sprintf("%v is in an NSEC3 opt-out span", nextCloser)
+	}
+	for _, n := range nsec3s {
+		if n.Match(wildcard) && !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, dns.TypeCNAME) {
+			return DNSSECSecure, ""
+		}
+	}
+	return DNSSECBogus, fmt.Sprintf("no NSEC3 proof of no data for %v %v", qname, dns.TypeToString[qtype])
+}
+
+// RFC 5155 8.3, the closest ancestor of qname that exists, and the covered name one label below it towards qname.
+// optOut is the opt-out flag of the NSEC3 covering the next closer name.
+func closestEncloserProof(qname string, nsec3s []*dns.NSEC3) (string, string, bool, bool) {
+	nextCloser := qname
+	for name := parentName(qname); ; name = parentName(name) {
+		for _, n := range nsec3s {
+			if !n.Match(name) {
+				continue
+			}
+			// a delegation or a DNAME ends the zone, nothing below it is proved
+			if isDelegation(n.TypeBitMap) || hasType(n.TypeBitMap, dns.TypeDNAME) {
+				return "", "", false, false
+			}
+			for _, c := range nsec3s {
+				if c.Cover(nextCloser) && !c.Match(nextCloser) {
+					return name, nextCloser, c.Flags&1 == 1, true
+				}
+			}
+			return "", "", false, false
+		}
+		if name == "." {
+			return "", "", false, false
+		}
+		nextCloser = name
+	}
+}
+
+func coveredNSEC3(name string, nsec3s []*dns.NSEC3) bool {
+	for _, n := range nsec3s {
+		if n.Cover(name) && !n.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// the longest ancestor of qname that an NSEC covering it proves to exist, the owner or next name or one of their ancestors
+func closestEncloser(n *dns.NSEC, qname string) string {
+	common := max(dns.CompareDomainName(qname, n.Hdr.Name), dns.CompareDomainName(qname, n.NextDomain))
+	labels := dns.Split(qname)
+	if common >= len(labels) {
+		return qname
+	}
+	if common == 0 {
+		return "."
+	}
+	return qname[labels[len(labels)-common]:]
+}
+
+// whether n is the NSEC of a delegation point above name, which proves nothing about the names in the child zone
+func aboveCut(n *dns.NSEC, name string) bool {
+	owner := dns.CanonicalName(n.Hdr.Name)
+	return owner != name && dns.IsSubDomain(owner, name) && isDelegation(n.TypeBitMap)
+}
+
+// the types of a delegation point: NS without SOA
+func isDelegation(bitmap []uint16) bool {
+	return hasType(bitmap, dns.TypeNS) && !hasType(bitmap, dns.TypeSOA)
+}
+
+// whether an NSEC record spans name in canonical order
+func covers(n *dns.NSEC, name string) bool {
+	owner, next := dns.CanonicalName(n.Hdr.Name), dns.CanonicalName(n.NextDomain)
+	after := canonicalCompare(owner, name) < 0
+	before := canonicalCompare(name, next) < 0
+	if canonicalCompare(owner, next) >= 0 {
+		// the last NSEC of the zone wraps around to the apex
+		return after || before
+	}
+	return after && before
+}
+
+// RFC 4034 6.1, names compare label by label from the right
+func canonicalCompare(a string, b string) int {
+	la := dns.SplitDomainName(dns.CanonicalName(a))
+	lb := dns.SplitDomainName(dns.CanonicalName(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// split a section into RRsets and the signatures covering them
+func groupRRsets(section []dns.RR) (map[rrsetKey][]dns.RR, map[rrsetKey][]*dns.RRSIG) {
+	sets := make(map[rrsetKey][]dns.RR)
+	sigs := make(map[rrsetKey][]*dns.RRSIG)
+	for _, rr := range section {
+		name := dns.CanonicalName(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{name, sig.TypeCovered}
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		key := rrsetKey{name, rr.Header().Rrtype}
+		sets[key] = append(sets[key], rr)
+	}
+	return sets, sigs
+}
+
+func parentName(name string) string {
+	i, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[i:]
+}
+
+// whether status a is worse than b, bogus is the worst
+func worse(a DNSSECStatus, b DNSSECStatus) bool {
+	rank := map[DNSSECStatus]int{DNSSECSecure: 0, DNSSECInsecure: 1, DNSSECIndeterminate: 2, DNSSECBogus: 3}
+	return rank[a] > rank[b]
+}
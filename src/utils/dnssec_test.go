@@ -0,0 +1,449 @@
+package utils
+
+import (
+	"crypto"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// a zone signed with one key, served by a local server that answers from canned responses
+type testZone struct {
+	origin    string
+	key       *dns.DNSKEY
+	signer    crypto.Signer
+	responses map[rrsetKey]*dns.Msg
+	delay     time.Duration // before answering a DNSKEY query
+}
+
+func newTestZone(t *testing.T, origin string) *testZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := &testZone{origin: origin, key: key, signer: priv.(crypto.Signer), responses: make(map[rrsetKey]*dns.Msg)}
+	z.answer(origin, dns.TypeDNSKEY, key)
+	return z
+}
+
+func (z *testZone) rr(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR("$ORIGIN " + z.origin + "\n$TTL 3600\n" + s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func (z *testZone) soa(t *testing.T) dns.RR {
+	return z.rr(t, "@ SOA ns hostmaster 1 7200 3600 1209600 3600")
+}
+
+// rrs grouped into RRsets, each followed by its signature
+func (z *testZone) signed(rrs ...dns.RR) []dns.RR {
+	sets, _ := groupRRsets(rrs)
+	keys := make([]rrsetKey, 0, len(sets))
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].name < keys[j].name || keys[i].name == keys[j].name && keys[i].rrtype < keys[j].rrtype
+	})
+
+	signed := make([]dns.RR, 0)
+	for _, key := range keys {
+		set := dns.Dedup(sets[key], nil)
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: 3600},
+			Algorithm:  z.key.Algorithm,
+			Expiration: uint32(time.Now().Add(24 * time.Hour).Unix()),
+			Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+			KeyTag:     z.key.KeyTag(),
+			SignerName: z.origin,
+		}
+		if err := sig.Sign(z.signer, set); err != nil {
+			panic(err)
+		}
+		signed = append(append(signed, set...), sig)
+	}
+	return signed
+}
+
+func (z *testZone) answer(qname string, qtype uint16, rrs ...dns.RR) {
+	m := new(dns.Msg)
+	m.Answer = z.signed(rrs...)
+	z.responses[rrsetKey{dns.CanonicalName(qname), qtype}] = m
+}
+
+func (z *testZone) deny(qname string, qtype uint16, rcode int, rrs ...dns.RR) {
+	m := new(dns.Msg)
+	m.Rcode = rcode
+	m.Ns = z.signed(rrs...)
+	z.responses[rrsetKey{dns.CanonicalName(qname), qtype}] = m
+}
+
+// rrs owned by a wildcard, signed and then expanded to name, with the proof rrs in the authority section
+func (z *testZone) expand(qname string, qtype uint16, name string, rrs []dns.RR, proof ...dns.RR) {
+	m := new(dns.Msg)
+	for _, rr := range z.signed(rrs...) {
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		m.Answer = append(m.Answer, rr)
+	}
+	m.Ns = z.signed(proof...)
+	z.responses[rrsetKey{dns.CanonicalName(qname), qtype}] = m
+}
+
+func (z *testZone) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	if q.Qtype == dns.TypeDNSKEY {
+		time.Sleep(z.delay)
+	}
+	resp := new(dns.Msg)
+	canned, ok := z.responses[rrsetKey{dns.CanonicalName(q.Name), q.Qtype}]
+	if !ok {
+		resp.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(resp)
+		return
+	}
+	resp.SetRcode(req, canned.Rcode)
+	resp.Answer, resp.Ns = canned.Answer, canned.Ns
+	resp.SetEdns0(4096, true)
+	w.WriteMsg(resp)
+}
+
+// a resolver asking a local server for z, with the key of z as trust anchor
+func (z *testZone) resolver(t *testing.T) *Resolver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: z}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return &Resolver{Server: pc.LocalAddr().String(), TrustAnchors: []dns.RR{z.key.ToDS(dns.SHA256)}, Timeout: 2 * time.Second}
+}
+
+func checkStatus(t *testing.T, r *Resolver, qname string, qtype uint16, want DNSSECStatus) {
+	t.Helper()
+	_, result, err := r.lookup(qname, qtype)
+	if err != nil {
+		t.Fatalf("%v %v: %v", qname, dns.TypeToString[qtype], err)
+	}
+	if result.Status != want {
+		t.Errorf("%v %v: %v (%v), want %v", qname, dns.TypeToString[qtype], result.Status, result.Reason, want)
+	}
+}
+
+// example. with an NSEC chain: example. -> _imaps._tcp.example. -> mail.example. -> example.
+func nsecZone(t *testing.T) (*testZone, map[string]dns.RR) {
+	z := newTestZone(t, "example.")
+	nsec := map[string]dns.RR{
+		"example.":             z.rr(t, "@ NSEC _imaps._tcp NS SOA RRSIG NSEC DNSKEY"),
+		"_imaps._tcp.example.": z.rr(t, "_imaps._tcp NSEC mail SRV RRSIG NSEC"),
+		"mail.example.":        z.rr(t, "mail NSEC @ A RRSIG NSEC"),
+	}
+	z.answer("_imaps._tcp.example.", dns.TypeSRV, z.rr(t, "_imaps._tcp SRV 0 1 993 mail"))
+	return z, nsec
+}
+
+func TestLookupNSEC(t *testing.T) {
+	z, nsec := nsecZone(t)
+	soa := z.soa(t)
+
+	z.deny("mail.example.", dns.TypeMX, dns.RcodeSuccess, soa, nsec["mail.example."])
+	// covered by example. -> _imaps._tcp.example., like the wildcard at its closest encloser _tcp.example.
+	z.deny("_imap._tcp.example.", dns.TypeSRV, dns.RcodeNameError, soa, nsec["example."])
+	// covered by mail.example. -> example., the wildcard *.example. by example. -> _imaps._tcp.example.
+	z.deny("www.example.", dns.TypeA, dns.RcodeNameError, soa, nsec["mail.example."], nsec["example."])
+	// _tcp.example. is an empty non-terminal
+	z.deny("_tcp.example.", dns.TypeTXT, dns.RcodeSuccess, soa, nsec["example."])
+
+	r := z.resolver(t)
+	checkStatus(t, r, "_imaps._tcp.example.", dns.TypeSRV, DNSSECSecure)
+	checkStatus(t, r, "mail.example.", dns.TypeMX, DNSSECSecure)
+	checkStatus(t, r, "_imap._tcp.example.", dns.TypeSRV, DNSSECSecure)
+	checkStatus(t, r, "www.example.", dns.TypeA, DNSSECSecure)
+	checkStatus(t, r, "_tcp.example.", dns.TypeTXT, DNSSECSecure)
+}
+
+// signed NSEC records that don't deny the question
+func TestLookupNSECForged(t *testing.T) {
+	z, nsec := nsecZone(t)
+	soa := z.soa(t)
+
+	// the NSEC of another name
+	z.deny("_imaps._tcp.example.", dns.TypeMX, dns.RcodeSuccess, soa, nsec["mail.example."])
+	// the NSEC of qname lists the type
+	z.deny("mail.example.", dns.TypeA, dns.RcodeSuccess, soa, nsec["mail.example."])
+	// NXDOMAIN for a name that has an NSEC
+	z.deny("mail.example.", dns.TypeAAAA, dns.RcodeNameError, soa, nsec["mail.example."])
+	// NXDOMAIN without the proof that there is no wildcard *.example.
+	z.deny("www.example.", dns.TypeA, dns.RcodeNameError, soa, nsec["mail.example."])
+	// NXDOMAIN for an empty non-terminal
+	z.deny("_tcp.example.", dns.TypeA, dns.RcodeNameError, soa, nsec["example."])
+	// no NSEC at all
+	z.deny("ftp.example.", dns.TypeA, dns.RcodeNameError, soa)
+
+	r := z.resolver(t)
+	checkStatus(t, r, "_imaps._tcp.example.", dns.TypeMX, DNSSECBogus)
+	checkStatus(t, r, "mail.example.", dns.TypeA, DNSSECBogus)
+	checkStatus(t, r, "mail.example.", dns.TypeAAAA, DNSSECBogus)
+	checkStatus(t, r, "www.example.", dns.TypeA, DNSSECBogus)
+	checkStatus(t, r, "_tcp.example.", dns.TypeA, DNSSECBogus)
+	checkStatus(t, r, "ftp.example.", dns.TypeA, DNSSECBogus)
+}
+
+// example3. with an NSEC3 chain over example3., _tcp.example3. (empty), _imaps._tcp.example3. and mail.example3.
+type nsec3Chain struct {
+	zone   *testZone
+	salt   string
+	hashes []string
+	types  map[string][]uint16 // by hash
+}
+
+// the salt is chosen so that the NSEC3 of _tcp.example3. doesn't also cover the wildcard *._tcp.example3.
+func nsec3Zone(t *testing.T) *nsec3Chain {
+	z := newTestZone(t, "example3.")
+	names := map[string][]uint16{
+		"example3.":             {dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		"_tcp.example3.":        {},
+		"_imaps._tcp.example3.": {dns.TypeSRV, dns.TypeRRSIG},
+		"mail.example3.":        {dns.TypeA, dns.TypeRRSIG},
+	}
+	z.answer("_imaps._tcp.example3.", dns.TypeSRV, z.rr(t, "_imaps._tcp SRV 0 1 993 mail"))
+
+	for i := 0; i < 256; i++ {
+		c := &nsec3Chain{zone: z, salt: fmt.Sprintf("%02x", i), types: make(map[string][]uint16)}
+		for name, types := range names {
+			h := dns.HashName(name, dns.SHA1, 0, c.salt)
+			c.hashes = append(c.hashes, h)
+			c.types[h] = types
+		}
+		sort.Strings(c.hashes)
+		if c.covering("*._tcp.example3.") != c.index("_tcp.example3.") {
+			return c
+		}
+	}
+	t.Fatal("no salt separates the wildcard from its closest encloser")
+	return nil
+}
+
+// the NSEC3 of the hash at i, with the opt-out flag if optOut
+func (c *nsec3Chain) record(i int, optOut bool) *dns.NSEC3 {
+	n := &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: strings.ToLower(c.hashes[i]) + "." + c.zone.origin, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+		Hash:       dns.SHA1,
+		Iterations: 0,
+		SaltLength: uint8(len(c.salt) / 2),
+		Salt:       c.salt,
+		HashLength: 20,
+		NextDomain: c.hashes[(i+1)%len(c.hashes)],
+		TypeBitMap: c.types[c.hashes[i]],
+	}
+	if optOut {
+		n.Flags = 1
+	}
+	return n
+}
+
+func (c *nsec3Chain) index(name string) int {
+	for i := range c.hashes {
+		if c.record(i, false).Match(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *nsec3Chain) matching(name string) *dns.NSEC3 {
+	return c.record(c.index(name), false)
+}
+
+func (c *nsec3Chain) covering(name string) int {
+	for i := range c.hashes {
+		if n := c.record(i, false); n.Cover(name) && !n.Match(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestLookupNSEC3(t *testing.T) {
+	c := nsec3Zone(t)
+	z := c.zone
+	soa := z.soa(t)
+
+	z.deny("mail.example3.", dns.TypeMX, dns.RcodeSuccess, soa, c.matching("mail.example3."))
+
+	// a name whose closest encloser, next closer name and wildcard each have their own record,
+	// so that leaving one of them out leaves a gap
+	qname := ""
+	for i := 0; qname == "" && i < 100; i++ {
+		name := "_imap" + strings.Repeat("x", i) + "._tcp.example3."
+		if covering := c.covering(name); covering != c.covering("*._tcp.example3.") && covering != c.index("_tcp.example3.") {
+			qname = name
+		}
+	}
+	if qname == "" {
+		t.Fatal("no name with a next closer record of its own")
+	}
+	encloser := c.matching("_tcp.example3.")
+	nextCloser := c.covering(qname)
+	wildcard := c.record(c.covering("*._tcp.example3."), false)
+	z.deny(qname, dns.TypeSRV, dns.RcodeNameError, soa, encloser, c.record(nextCloser, false), wildcard)
+	z.deny(qname, dns.TypeTXT, dns.RcodeNameError, soa, encloser, c.record(nextCloser, false))
+	z.deny(qname, dns.TypeA, dns.RcodeNameError, soa, encloser, c.record(nextCloser, true), wildcard)
+	z.deny(qname, dns.TypeAAAA, dns.RcodeNameError, soa, c.record(nextCloser, false), wildcard)
+
+	// the NSEC3 of qname lists the type
+	z.deny("mail.example3.", dns.TypeA, dns.RcodeSuccess, soa, c.matching("mail.example3."))
+	// NXDOMAIN for a name that exists
+	z.deny("mail.example3.", dns.TypeAAAA, dns.RcodeNameError, soa, c.matching("example3."), c.matching("mail.example3."))
+
+	r := z.resolver(t)
+	checkStatus(t, r, "_imaps._tcp.example3.", dns.TypeSRV, DNSSECSecure)
+	checkStatus(t, r, "mail.example3.", dns.TypeMX, DNSSECSecure)
+	checkStatus(t, r, qname, dns.TypeSRV, DNSSECSecure)
+	// without the wildcard proof
+	checkStatus(t, r, qname, dns.TypeTXT, DNSSECBogus)
+	// the next closer name is in an opt-out span
+	checkStatus(t, r, qname, dns.TypeA, DNSSECInsecure)
+	// without the closest encloser
+	checkStatus(t, r, qname, dns.TypeAAAA, DNSSECBogus)
+	checkStatus(t, r, "mail.example3.", dns.TypeA, DNSSECBogus)
+	checkStatus(t, r, "mail.example3.", dns.TypeAAAA, DNSSECBogus)
+}
+
+// concurrent lookups must not see each other's unfinished key lookups
+func TestLookupConcurrent(t *testing.T) {
+	z, _ := nsecZone(t)
+	z.delay = 50 * time.Millisecond
+	r := z.resolver(t)
+
+	var wg sync.WaitGroup
+	results := make([]DNSSECResult, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i], _ = r.lookup("_imaps._tcp.example.", dns.TypeSRV)
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if result.Status != DNSSECSecure {
+			t.Errorf("lookup %v: %v (%v)", i, result.Status, result.Reason)
+		}
+	}
+}
+
+func TestLookupWrongAnchor(t *testing.T) {
+	z, _ := nsecZone(t)
+	r := z.resolver(t)
+	other := newTestZone(t, "example.")
+	r.TrustAnchors = []dns.RR{other.key.ToDS(dns.SHA256)}
+	checkStatus(t, r, "_imaps._tcp.example.", dns.TypeSRV, DNSSECBogus)
+}
+
+// signed records of other names than the one asked for don't answer it
+func TestLookupForeignOwner(t *testing.T) {
+	z, _ := nsecZone(t)
+	z.answer("_imaps._tcp.victim.example.", dns.TypeSRV, z.rr(t, "_imaps._tcp.attacker SRV 0 1 993 evil.attacker.net."))
+	z.answer("imap.example.", dns.TypeA, z.rr(t, "imap CNAME mail"), z.rr(t, "mail A 192.0.2.1"), z.rr(t, "www A 192.0.2.2"))
+	r := z.resolver(t)
+
+	records, result, err := r.lookup("_imaps._tcp.victim.example.", dns.TypeSRV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 || result.Status == DNSSECSecure {
+		t.Errorf("records %v, %v (%v)", records, result.Status, result.Reason)
+	}
+
+	// the target of a CNAME belongs to the answer
+	records, result, err = r.lookup("imap.example.", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Header().Name != "mail.example." || result.Status != DNSSECSecure {
+		t.Errorf("records %v, %v (%v)", records, result.Status, result.Reason)
+	}
+}
+
+// RFC 4035 5.3.4, an answer expanded from *.example. needs proof that there is no closer name
+func TestLookupWildcard(t *testing.T) {
+	z, nsec := nsecZone(t)
+	wildcard := []dns.RR{z.rr(t, "* A 192.0.2.1")}
+	// mail.example. -> example. covers www.example.
+	z.expand("www.example.", dns.TypeA, "www.example.", wildcard, nsec["mail.example."])
+	z.expand("ftp.example.", dns.TypeA, "ftp.example.", wildcard)
+	// example. -> _imaps._tcp.example. covers neither the name nor its next closer name x.mail.example.
+	z.expand("a.x.mail.example.", dns.TypeA, "a.x.mail.example.", wildcard, nsec["example."])
+	// the next closer name of a.x.example. is x.example., covered by mail.example. -> example.
+	z.expand("a.x.example.", dns.TypeA, "a.x.example.", wildcard, nsec["mail.example."])
+
+	// a signature claiming more labels than its owner has
+	m := new(dns.Msg)
+	m.Answer = z.signed(z.rr(t, "mail A 192.0.2.1"))
+	m.Answer[1].(*dns.RRSIG).Labels = 3
+	z.responses[rrsetKey{"mail.example.", dns.TypeA}] = m
+
+	r := z.resolver(t)
+	checkStatus(t, r, "www.example.", dns.TypeA, DNSSECSecure)
+	checkStatus(t, r, "ftp.example.", dns.TypeA, DNSSECBogus)
+	checkStatus(t, r, "a.x.mail.example.", dns.TypeA, DNSSECBogus)
+	checkStatus(t, r, "a.x.example.", dns.TypeA, DNSSECSecure)
+	checkStatus(t, r, "mail.example.", dns.TypeA, DNSSECBogus)
+}
+
+// RFC 4034 2.1.1 and RFC 5011 7, a key without the zone flag or with the revoke flag signs nothing
+func TestLookupKeyFlags(t *testing.T) {
+	for _, flags := range []uint16{257 | dns.REVOKE, dns.SEP} {
+		z := newTestZone(t, "example.")
+		z.key.Flags = flags
+		z.answer("example.", dns.TypeDNSKEY, z.key)
+		z.answer("_imaps._tcp.example.", dns.TypeSRV, z.rr(t, "_imaps._tcp SRV 0 1 993 mail"))
+		checkStatus(t, z.resolver(t), "_imaps._tcp.example.", dns.TypeSRV, DNSSECBogus)
+	}
+}
+
+// the keys of a zone found while the search ran into a loop back to another zone are not kept
+func TestLookupLoop(t *testing.T) {
+	z := newTestZone(t, "example.")
+	b := newTestZone(t, "b.example.")
+	a := newTestZone(t, "a.b.example.")
+	a.answer("_imaps._tcp.a.b.example.", dns.TypeSRV, a.rr(t, "_imaps._tcp SRV 0 1 993 mail"))
+	b.answer("a.b.example.", dns.TypeDS, a.key.ToDS(dns.SHA256))
+	// the proof that there is no DS for b.example. is signed by its child a.b.example.
+	a.deny("b.example.", dns.TypeDS, dns.RcodeSuccess, a.soa(t), a.rr(t, "@ NSEC mail NS SOA RRSIG NSEC DNSKEY"))
+	for _, child := range []*testZone{a, b} {
+		for key, m := range child.responses {
+			z.responses[key] = m
+		}
+	}
+
+	r := z.resolver(t)
+	checkStatus(t, r, "_imaps._tcp.a.b.example.", dns.TypeSRV, DNSSECIndeterminate)
+	if _, ok := r.zones["b.example."]; ok {
+		t.Error("keys of b.example. cached from inside the loop")
+	}
+	if z, ok := r.zones["a.b.example."]; !ok || z.status != DNSSECIndeterminate {
+		t.Errorf("keys of a.b.example.: %+v", z)
+	}
+}
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ResponseClass string
+
+const (
+	ResponseValid     ResponseClass = "valid"
+	ResponseEmpty     ResponseClass = "empty"
+	ResponseHTML      ResponseClass = "html"       // parking pages, SPA index.html, web hosting error pages
+	ResponseNotXML    ResponseClass = "not-xml"    // body is not well-formed XML
+	ResponseWrongRoot ResponseClass = "wrong-root" // XML, but not the expected document
+	ResponseSoft404   ResponseClass = "soft-404"   // the host answers a random path with the same content
+)
+
+// bodies at least this similar to the random path probe are soft 404s
+const SoftNotFoundSimilarity = 0.9
+
+type Classification struct {
+	Class       ResponseClass
+	ContentType string
+	Root        string  // local name of the root element, if the body is XML
+	Similarity  float64 // similarity to the random path probe, 0 if no probe was made
+	Conclusive  bool    // the document has the elements of a real one, a random path probe can't tell more
+	Reason      string
+}
+
+// elements a real document has below its root, keyed by the lower case root.
+// a catch-all page rarely gets this far, a real server that answers every path with its config always does.
+var structuralPaths = map[string][]string{
+	"clientconfig": {"emailProvider", "incomingServer"},
+	"autodiscover": {"Response", "Account"},
+}
+
+// a HTTP 200 response that is not the requested document
+type RejectedResponseError struct {
+	URL            string
+	Classification Classification
+}
+
+func (e *RejectedResponseError) Error() string {
+	return fmt.Sprintf("rejected response from %v: %v (%v)", e.URL, e.Classification.Class, e.Classification.Reason)
+}
+
+// all candidates of one discovery mechanism failed, Attempts holds the error of each candidate
+type NotFoundError struct {
+	Mechanism    string // "Autoconfigxml" or "Autodiscoverxml"
+	EmailAddress string
+	Attempts     []error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("can't find %v file for %v", e.Mechanism, e.EmailAddress)
+}
+
+func (e *NotFoundError) Unwrap() []error {
+	return e.Attempts
+}
+
+// classify a HTTP 200 response body, expectedRoot is the local name of the root element
+// ("clientConfig" for autoconfig, "Autodiscover" for autodiscover)
+func Classify_Response(contentType string, body []byte, expectedRoot string) Classification {
+	c := Classification{ContentType: contentType}
+	mediatype, _, _ := mime.ParseMediaType(contentType)
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		c.Class = ResponseEmpty
+		c.Reason = "empty body"
+		return c
+	}
+
+	if Is_HTML(trimmed) {
+		c.Class = ResponseHTML
+		c.Reason = "body is an HTML page"
+		return c
+	}
+
+	root, paths, err := xmlStructure(trimmed)
+	if err != nil {
+		c.Class = ResponseNotXML
+		c.Reason = err.Error()
+		if mediatype == "text/html" {
+			c.Class = ResponseHTML
+			c.Reason = "text/html response that is not XML"
+		}
+		return c
+	}
+	c.Root = root
+
+	if !strings.EqualFold(root, expectedRoot) {
+		c.Class = ResponseWrongRoot
+		c.Reason = fmt.Sprintf("root element is <%s>, expected <%s>", root, expectedRoot)
+		return c
+	}
+
+	c.Class = ResponseValid
+	if expected, ok := structuralPaths[strings.ToLower(root)]; ok {
+		c.Conclusive = paths[strings.ToLower(strings.Join(append([]string{root}, expected...), "/"))]
+	}
+	return c
+}
+
+// a valid looking response is only compared with a random path when the structural checks can't tell
+func Needs_SoftNotFoundProbe(c Classification) bool {
+	return c.Class == ResponseValid && !c.Conclusive
+}
+
+// compare a valid looking body with the response to a random path on the same host
+func Check_SoftNotFound(c Classification, body []byte, probe []byte) Classification {
+	if c.Class != ResponseValid || probe == nil {
+		return c
+	}
+
+	c.Similarity = Similarity(body, probe)
+	if c.Similarity >= SoftNotFoundSimilarity {
+		c.Class = ResponseSoft404
+		c.Reason = fmt.Sprintf("%.0f%% similar to a random path on the same host", c.Similarity*100)
+	}
+	return c
+}
+
+// request a random path on the host of rawurl with the same method, the body is nil unless the answer is HTTP 200
+func Probe_RandomPath(client *http.Client, method string, rawurl string, contentType string, reqbody []byte) ([]byte, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	u.Path = "/" + hex.EncodeToString(random) + "/" + hex.EncodeToString(random[:6]) + ".xml"
+	u.RawQuery = ""
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(reqbody))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// similarity of two bodies between 0 and 1, Jaccard index of their word trigrams
+func Similarity(a []byte, b []byte) float64 {
+	if bytes.Equal(a, b) {
+		return 1
+	}
+
+	sa := shingles(a)
+	sb := shingles(b)
+	if len(sa) == 0 || len(sb) == 0 {
+		return 0
+	}
+
+	common := 0
+	for s := range sa {
+		if sb[s] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(sa)+len(sb)-common)
+}
+
+func shingles(data []byte) map[string]bool {
+	words := strings.Fields(string(data))
+	set := make(map[string]bool)
+	if len(words) < 3 {
+		if len(words) > 0 {
+			set[strings.Join(words, " ")] = true
+		}
+		return set
+	}
+	for i := 0; i+3 <= len(words); i++ {
+		set[strings.Join(words[i:i+3], " ")] = true
+	}
+	return set
+}
+
+func Is_HTML(data []byte) bool {
+	head := bytes.TrimSpace(data)
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	head = bytes.ToLower(head)
+	return bytes.HasPrefix(head, []byte("<!doctype html")) ||
+		bytes.Contains(head, []byte("<html")) ||
+		bytes.Contains(head, []byte("<head>")) ||
+		bytes.Contains(head, []byte("<body"))
+}
+
+// check the document is well-formed and return the local name of its root element,
+// with the lower case paths of local names ("clientconfig/emailprovider") of the elements in it
+func xmlStructure(data []byte) (string, map[string]bool, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	root := ""
+	paths := make(map[string]bool)
+	stack := make([]string, 0)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return root, paths, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if root == "" {
+				root = t.Name.Local
+			}
+			stack = append(stack, strings.ToLower(t.Name.Local))
+			paths[strings.Join(stack, "/")] = true
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if root == "" {
+		return "", paths, fmt.Errorf("no root element")
+	}
+	return root, paths, nil
+}
+
+// per-domain results of a discovery run, false positives are counted apart from real configs
+type ResponseStats struct {
+	Found         int                   // domains with an accepted document
+	NotFound      int                   // domains without
+	FalsePositive int                   // domains not found that had HTTP 200 responses rejected as false positives
+	Rejected      map[ResponseClass]int // rejected responses by class
+}
+
+// record the error returned by `Download_AutoconfigXML` or `Download_AutodiscoverXML` for one domain
+func (s *ResponseStats) Record(err error) {
+	if s.Rejected == nil {
+		s.Rejected = make(map[ResponseClass]int)
+	}
+	if err == nil {
+		s.Found++
+		return
+	}
+	s.NotFound++
+
+	rejected := 0
+	var walk func(err error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case *RejectedResponseError:
+			s.Rejected[e.Classification.Class]++
+			rejected++
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		default:
+			if inner := errors.Unwrap(err); inner != nil {
+				walk(inner)
+			}
+		}
+	}
+	walk(err)
+
+	if rejected > 0 {
+		s.FalsePositive++
+	}
+}
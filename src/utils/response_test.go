@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const clientConfig = `<?xml version="1.0"?>
+<clientConfig version="1.1"><emailProvider id="example.com"><incomingServer type="imap"><hostname>imap.example.com</hostname></incomingServer></emailProvider></clientConfig>`
+
+func TestClassifyResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		root        string
+		class       ResponseClass
+		conclusive  bool
+	}{
+		{"autoconfig", "text/xml", clientConfig, "clientConfig", ResponseValid, true},
+		{"root case", "application/xml", `<ClientConfig><emailProvider><incomingServer/></emailProvider></ClientConfig>`, "clientConfig", ResponseValid, true},
+		{"no servers", "text/xml", `<clientConfig><emailProvider id="x"/></clientConfig>`, "clientConfig", ResponseValid, false},
+		{"autodiscover", "text/xml", `<Autodiscover xmlns="x"><Response><Account><Action>settings</Action></Account></Response></Autodiscover>`, "Autodiscover", ResponseValid, true},
+		{"autodiscover error", "text/xml", `<Autodiscover><Response><Error><ErrorCode>600</ErrorCode></Error></Response></Autodiscover>`, "Autodiscover", ResponseValid, false},
+		{"unknown root", "text/xml", `<config><a/></config>`, "config", ResponseValid, false},
+		{"empty", "text/xml", " \r\n ", "clientConfig", ResponseEmpty, false},
+		{"html", "text/html", "<!DOCTYPE html><html><body>parked</body></html>", "clientConfig", ResponseHTML, false},
+		{"html as xml", "text/xml", "<html><head><title>404</title></head></html>", "clientConfig", ResponseHTML, false},
+		{"text/html not xml", "text/html; charset=utf-8", "not found", "clientConfig", ResponseHTML, false},
+		{"not xml", "text/plain", "not found", "clientConfig", ResponseNotXML, false},
+		{"malformed", "text/xml", "<clientConfig><emailProvider>", "clientConfig", ResponseNotXML, false},
+		{"wrong root", "text/xml", `<Autodiscover><Response/></Autodiscover>`, "clientConfig", ResponseWrongRoot, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Classify_Response(test.contentType, []byte(test.body), test.root)
+			if c.Class != test.class || c.Conclusive != test.conclusive {
+				t.Errorf("got %v (conclusive %v, %q), want %v (conclusive %v)", c.Class, c.Conclusive, c.Reason, test.class, test.conclusive)
+			}
+			if c.Class != ResponseValid && c.Reason == "" {
+				t.Error("rejected without a reason")
+			}
+			if Needs_SoftNotFoundProbe(c) != (test.class == ResponseValid && !test.conclusive) {
+				t.Errorf("needs probe %v", Needs_SoftNotFoundProbe(c))
+			}
+		})
+	}
+}
+
+func TestCheckSoftNotFound(t *testing.T) {
+	page := `<config><message>Welcome to the hosting of example.com, this domain is parked and has no content yet</message></config>`
+	valid := Classify_Response("text/xml", []byte(page), "config")
+
+	c := Check_SoftNotFound(valid, []byte(page), []byte(page))
+	if c.Class != ResponseSoft404 || c.Similarity != 1 || c.Reason == "" {
+		t.Errorf("same page: %+v", c)
+	}
+
+	other := `<html><body>404 not found</body></html>`
+	if c := Check_SoftNotFound(valid, []byte(page), []byte(other)); c.Class != ResponseValid || c.Similarity >= SoftNotFoundSimilarity {
+		t.Errorf("different page: %+v", c)
+	}
+
+	// no probe body (the random path is not HTTP 200) and rejected responses are left alone
+	if c := Check_SoftNotFound(valid, []byte(page), nil); c.Class != ResponseValid || c.Similarity != 0 {
+		t.Errorf("no probe: %+v", c)
+	}
+	html := Classify_Response("text/html", []byte(other), "config")
+	if c := Check_SoftNotFound(html, []byte(other), []byte(other)); c.Class != ResponseHTML {
+		t.Errorf("rejected response: %+v", c)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	words := func(n int, prefix string) string {
+		w := make([]string, n)
+		for i := range w {
+			w[i] = fmt.Sprintf("%v%d", prefix, i)
+		}
+		return strings.Join(w, " ")
+	}
+
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{"equal", "a b c d", "a b c d", 1, 1},
+		{"both empty", "", "", 1, 1},
+		{"one empty", "a b c", "", 0, 0},
+		{"disjoint", words(20, "x"), words(20, "y"), 0, 0},
+		{"whitespace only differs", "a  b\tc\nd", "a b c d", 1, 1},
+		{"short", "a b", "a b", 1, 1},
+		// a request id in a page of 100 words changes 3 of 98 trigrams
+		{"one word differs", words(100, "w") + " id1", words(100, "w") + " id2", 0.9, 0.99},
+		{"half shared", words(50, "w"), words(25, "w"), 0.45, 0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := Similarity([]byte(test.a), []byte(test.b))
+			if s < test.min || s > test.max {
+				t.Errorf("similarity %v, want %v - %v", s, test.min, test.max)
+			}
+			if r := Similarity([]byte(test.b), []byte(test.a)); r != s {
+				t.Errorf("not symmetric: %v, %v", s, r)
+			}
+		})
+	}
+}
+
+func TestResponseStatsRecord(t *testing.T) {
+	var stats ResponseStats
+	stats.Record(nil)
+	stats.Record(&NotFoundError{Mechanism: "Autoconfigxml", Attempts: []error{
+		fmt.Errorf("error downloading file: https://a.example/"),
+		&RejectedResponseError{URL: "https://b.example/", Classification: Classification{Class: ResponseHTML}},
+		fmt.Errorf("wrapped: %w", &RejectedResponseError{URL: "http://c.example/", Classification: Classification{Class: ResponseSoft404}}),
+	}})
+	stats.Record(&NotFoundError{Mechanism: "Autoconfigxml", Attempts: []error{fmt.Errorf("connection refused")}})
+
+	if stats.Found != 1 || stats.NotFound != 2 || stats.FalsePositive != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats.Rejected[ResponseHTML] != 1 || stats.Rejected[ResponseSoft404] != 1 || len(stats.Rejected) != 2 {
+		t.Errorf("rejected %v", stats.Rejected)
+	}
+}
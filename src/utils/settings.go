@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// where a MailSettings came from
+type MailSource string
+
+const (
+	SourceAutoconfig   MailSource = "autoconfig"
+	SourceAutodiscover MailSource = "autodiscover"
+	SourceSRV          MailSource = "srv"   // RFC 6186
+	SourceGuess        MailSource = "guess" // probing common hostnames, like Thunderbird's guessConfig
+)
+
+type MailProtocol string
+
+const (
+	MailIMAP MailProtocol = "imap"
+	MailPOP3 MailProtocol = "pop3"
+	MailSMTP MailProtocol = "smtp"
+)
+
+// how the connection is secured
+type Security string
+
+const (
+	SecurityTLS      Security = "tls"      // implicit TLS from the first byte, autoconfig "SSL"
+	SecuritySTARTTLS Security = "starttls" // plain text upgraded with STARTTLS or STLS
+	SecurityPlain    Security = "plain"
+	SecurityUnknown  Security = "" // the source leaves it to the client, e.g. Autodiscover Encryption "Auto"
+)
+
+// one server of a configuration, normalized from whatever the source calls it
+type MailServer struct {
+	Protocol       MailProtocol
+	Hostname       string // lower case, without the trailing dot
+	Port           int
+	Security       Security
+	Username       string // "" if the source doesn't say, clients then use the email address
+	Authentication string // in autoconfig terms, e.g. "password-cleartext", "NTLM", "OAuth2", "" if unknown
+}
+
+// where and how to connect for one email address, according to one source
+type MailSettings struct {
+	Source       MailSource
+	EmailAddress string
+	Incoming     []MailServer // IMAP and POP3, in order of preference
+	Outgoing     []MailServer // SMTP, in order of preference
+	Unavailable  []string     // services the source says are not offered, e.g. the SRV service "_imap._tcp" with the target "."
+}
+
+func (s MailServer) Address() string {
+	return net.JoinHostPort(s.Hostname, strconv.Itoa(s.Port))
+}
+
+func (s MailServer) String() string {
+	security := s.Security
+	if security == SecurityUnknown {
+		security = "unknown"
+	}
+	return fmt.Sprintf("%v %v (%v)", s.Protocol, s.Address(), security)
+}
+
+// the server a client would use first, nil if there is none
+func (m *MailSettings) Preferred_Incoming() *MailServer {
+	if len(m.Incoming) == 0 {
+		return nil
+	}
+	return &m.Incoming[0]
+}
+
+func (m *MailSettings) Preferred_Outgoing() *MailServer {
+	if len(m.Outgoing) == 0 {
+		return nil
+	}
+	return &m.Outgoing[0]
+}
+
+// add s to Incoming or Outgoing depending on its protocol
+func (m *MailSettings) Add(s MailServer) {
+	if s.Protocol == MailSMTP {
+		m.Outgoing = append(m.Outgoing, s)
+	} else {
+		m.Incoming = append(m.Incoming, s)
+	}
+}
+
+// the registered port for protocol and security, RFC 8314 and RFC 6409. 0 if the security is unknown.
+func Default_Port(protocol MailProtocol, security Security) int {
+	switch security {
+	case SecurityTLS:
+		switch protocol {
+		case MailIMAP:
+			return 993
+		case MailPOP3:
+			return 995
+		case MailSMTP:
+			return 465
+		}
+	case SecuritySTARTTLS, SecurityPlain:
+		switch protocol {
+		case MailIMAP:
+			return 143
+		case MailPOP3:
+			return 110
+		case MailSMTP:
+			return 587
+		}
+	}
+	return 0
+}
+
+// the security of autoconfig socketType values {"plain", "SSL", "STARTTLS"}, "TLS" is accepted as in Thunderbird
+func Security_FromSocketType(socketType string) Security {
+	switch strings.ToUpper(strings.TrimSpace(socketType)) {
+	case "SSL", "TLS":
+		return SecurityTLS
+	case "STARTTLS":
+		return SecuritySTARTTLS
+	case "PLAIN":
+		return SecurityPlain
+	}
+	return SecurityUnknown
+}
+
+// settings from the RFC 6186 SRV records of the domain of email_address.
+// servers are ordered by priority, then weight, records of other services are left out.
+// a service with the target "." is not available (RFC 6186 4) and goes to Unavailable instead.
+// _imap, _pop3 and _submission are plain text ports that must be upgraded with STARTTLS (RFC 8314 4.1).
+func MailSettings_FromSRV(email_address string, targets []SRVTarget) *MailSettings {
+	sorted := make([]SRVTarget, len(targets))
+	copy(sorted, targets)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].Weight > sorted[j].Weight
+	})
+
+	settings := &MailSettings{Source: SourceSRV, EmailAddress: email_address}
+	unavailable := make(map[string]bool)
+	for _, t := range sorted {
+		server, ok := srvServers[t.Service]
+		if !ok {
+			continue
+		}
+		server.Hostname = strings.ToLower(strings.TrimSuffix(t.Target, "."))
+		server.Port = int(t.Port)
+		if server.Hostname == "" {
+			if !unavailable[t.Service] {
+				unavailable[t.Service] = true
+				settings.Unavailable = append(settings.Unavailable, t.Service)
+			}
+			continue
+		}
+		settings.Add(server)
+	}
+	return settings
+}
+
+// the protocol and security of each RFC 6186 and RFC 8314 service
+var srvServers = map[string]MailServer{
+	"_imap._tcp":        {Protocol: MailIMAP, Security: SecuritySTARTTLS},
+	"_imaps._tcp":       {Protocol: MailIMAP, Security: SecurityTLS},
+	"_pop3._tcp":        {Protocol: MailPOP3, Security: SecuritySTARTTLS},
+	"_pop3s._tcp":       {Protocol: MailPOP3, Security: SecurityTLS},
+	"_submission._tcp":  {Protocol: MailSMTP, Security: SecuritySTARTTLS},
+	"_submissions._tcp": {Protocol: MailSMTP, Security: SecurityTLS},
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMailSettingsFromSRV(t *testing.T) {
+	targets := []SRVTarget{
+		{Service: "_imap._tcp", Target: "imap2.example.com", Port: 143, Priority: 10, Weight: 0},
+		{Service: "_imap._tcp", Target: "IMAP.example.com", Port: 143, Priority: 0, Weight: 10},
+		{Service: "_pop3._tcp", Target: ".", Priority: 0},
+		{Service: "_pop3s._tcp", Target: ".", Priority: 0},
+		{Service: "_pop3s._tcp", Target: ".", Priority: 10},
+		{Service: "_submissions._tcp", Target: "smtp.example.com", Port: 465},
+		{Service: "_autodiscover._tcp", Target: ".", Port: 443},
+	}
+
+	settings := MailSettings_FromSRV("alice@example.com", targets)
+	incoming := []MailServer{
+		{Protocol: MailIMAP, Hostname: "imap.example.com", Port: 143, Security: SecuritySTARTTLS},
+		{Protocol: MailIMAP, Hostname: "imap2.example.com", Port: 143, Security: SecuritySTARTTLS},
+	}
+	if !reflect.DeepEqual(settings.Incoming, incoming) {
+		t.Errorf("incoming: %v", settings.Incoming)
+	}
+	if len(settings.Outgoing) != 1 || settings.Outgoing[0].String() != "smtp smtp.example.com:465 (tls)" {
+		t.Errorf("outgoing: %v", settings.Outgoing)
+	}
+	if want := []string{"_pop3._tcp", "_pop3s._tcp"}; !reflect.DeepEqual(settings.Unavailable, want) {
+		t.Errorf("unavailable: %q, want %q", settings.Unavailable, want)
+	}
+}
+
+func TestSummarizeSRVTargets(t *testing.T) {
+	targets := []SRVTarget{
+		{Service: "_imaps._tcp", Domain: "example.com", Target: "mail.example.com", Class: Classify_SRVTarget("example.com", "mail.example.com", DNSSECInsecure)},
+		{Service: "_pop3s._tcp", Domain: "example.com", Target: ".", Class: Classify_SRVTarget("example.com", ".", DNSSECInsecure)},
+		{Service: "_submissions._tcp", Domain: "example.com", Target: "smtp.example.net", Class: Classify_SRVTarget("example.com", "smtp.example.net", DNSSECInsecure)},
+	}
+
+	report := Summarize_SRVTargets(targets)
+	if report.Targets != 2 || report.ByClass[SRVUnavailable] != 1 || report.ByClass[SRVInDomain] != 1 || report.ByClass[SRVThirdParty] != 1 {
+		t.Errorf("report: %+v", report)
+	}
+	if len(report.Findings) != 1 {
+		t.Errorf("findings: %q", report.Findings)
+	}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// where an SRV record points relative to the queried domain, RFC 6186 section 6
+type SRVTargetClass string
+
+const (
+	SRVInDomain          SRVTargetClass = "in-domain"              // the queried domain or a name below it
+	SRVDelegatedProvider SRVTargetClass = "delegated-provider"     // outside the domain, but DNSSEC validated the record
+	SRVThirdParty        SRVTargetClass = "unverified-third-party" // outside the domain without DNSSEC, clients should warn the user
+	SRVUnavailable       SRVTargetClass = "unavailable"            // the target ".", the service is not offered (RFC 2782)
+)
+
+// one SRV record with its classification
+type SRVTarget struct {
+	Service  string // e.g. "_imap._tcp"
+	Domain   string // the queried domain
+	Target   string // without the trailing dot, "." if the service is not available
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	DNSSEC   DNSSECStatus
+	Class    SRVTargetClass
+}
+
+type SRVReport struct {
+	Targets  int // without the "." targets
+	ByClass  map[SRVTargetClass]int
+	Findings []string // one line per unverified third party target
+}
+
+// classify target of an SRV record found under domain
+func Classify_SRVTarget(domain string, target string, dnssec DNSSECStatus) SRVTargetClass {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+
+	if target == "" {
+		return SRVUnavailable
+	}
+	if target == domain || strings.HasSuffix(target, "."+domain) {
+		return SRVInDomain
+	}
+	if dnssec == DNSSECSecure {
+		return SRVDelegatedProvider
+	}
+	return SRVThirdParty
+}
+
+// look up _service._proto.domain with `Lookup_SRV` and classify the targets.
+// the "." target (RFC 2782, the service is not available) is kept as `SRVUnavailable`.
+func Lookup_SRVTargets(service string, proto string, domain string) ([]SRVTarget, DNSSECResult, error) {
+	domain, err := To_ASCIIHost(domain)
+	if err != nil {
+		return nil, DNSSECResult{}, err
+	}
+
+	srvs, dnssec, err := Lookup_SRV(service, proto, domain)
+	if err != nil {
+		return nil, dnssec, err
+	}
+
+	targets := make([]SRVTarget, 0, len(srvs))
+	for _, s := range srvs {
+		target, err := To_ASCIIHost(s.Target)
+		if err != nil {
+			continue
+		}
+		if target == "" {
+			target = "."
+		}
+		targets = append(targets, SRVTarget{
+			Service:  "_" + service + "._" + proto,
+			Domain:   domain,
+			Target:   target,
+			Port:     s.Port,
+			Priority: s.Priority,
+			Weight:   s.Weight,
+			DNSSEC:   dnssec.Status,
+			Class:    Classify_SRVTarget(domain, target, dnssec.Status),
+		})
+	}
+	return targets, dnssec, nil
+}
+
+// aggregate classified targets of any number of domains
+func Summarize_SRVTargets(targets []SRVTarget) SRVReport {
+	report := SRVReport{ByClass: make(map[SRVTargetClass]int)}
+	for _, t := range targets {
+		report.ByClass[t.Class]++
+		if t.Class == SRVUnavailable {
+			continue
+		}
+		report.Targets++
+		if t.Class == SRVThirdParty {
+			report.Findings = append(report.Findings, fmt.Sprintf("%v.%v points to %v outside the domain and is not DNSSEC validated (%v)", t.Service, t.Domain, t.Target, t.DNSSEC))
+		}
+	}
+	return report
+}